@@ -0,0 +1,69 @@
+package command
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/dnssec"
+	"github.com/spf13/cobra"
+)
+
+// NewDS returns the command that prints the DS records to publish in the
+// parents of DNSSEC-signed zones, as read from the same keys as the webhook.
+func NewDS() *cobra.Command {
+	var (
+		zones            []string
+		stateDir, keyDir string
+		cmd              = &cobra.Command{
+			Use:           "ds [KEYFILE...]",
+			Short:         "Print the DS records of DNSSEC keys for the parents of their zones",
+			SilenceErrors: true,
+			SilenceUsage:  true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				keys := []*dnssec.Key{}
+
+				for _, arg := range args {
+					k, err := dnssec.ReadKey(arg)
+					if err != nil {
+						return err
+					}
+
+					keys = append(keys, k)
+				}
+
+				if keyDir == "" && stateDir != "" {
+					keyDir = filepath.Join(stateDir, "keys")
+				}
+
+				if keyDir != "" {
+					for _, zone := range zones {
+						ks, err := dnssec.ReadKeys(keyDir, zone)
+						if err != nil {
+							return err
+						}
+
+						keys = append(keys, ks...)
+					}
+				}
+
+				if len(keys) == 0 {
+					return fmt.Errorf("no DNSSEC keys found")
+				}
+
+				for _, k := range keys {
+					fmt.Fprintln(cmd.OutOrStdout(), k.DS().String())
+				}
+
+				return nil
+			},
+		}
+	)
+
+	cmd.Flags().BoolP("help", "h", false, "Help for "+cmd.Name())
+
+	cmd.Flags().StringSliceVar(&zones, "zone", nil, "DNS zones to print the DS records of from --dnssec-key-dir")
+	cmd.Flags().StringVar(&stateDir, "state-dir", "", "Directory that records are persisted to across restarts")
+	cmd.Flags().StringVar(&keyDir, "dnssec-key-dir", "", "Directory that generated DNSSEC keys are kept in, <state-dir>/keys if unset")
+
+	return cmd
+}
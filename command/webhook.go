@@ -1,15 +1,14 @@
 package command
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
@@ -18,22 +17,35 @@ import (
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/coremain"
 	corednslog "github.com/coredns/coredns/plugin/pkg/log"
-	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
-	"github.com/frantjc/external-dns-dnsserver-webhook/hosts"
+	"github.com/frantjc/external-dns-dnsserver-webhook/coredns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/health"
 	"github.com/frantjc/external-dns-dnsserver-webhook/internal/logutil"
-	xurl "github.com/frantjc/x/net/url"
+	"github.com/miekg/dns"
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
-	"sigs.k8s.io/external-dns/provider/webhook/api"
 )
 
 func NewWebhook(version string) *cobra.Command {
 	var (
 		slogConfig                                                     = new(logutil.SlogConfig)
 		port, metricsPort, dnsHealthPort, dnsReadyPort, dnsMetricsPort int
-		dnsCache                                                       string
+		dnsCache, dnsDefaultTTL                                        string
+		dnsMaxAnswers                                                  int
 		dnsForwardServers                                              []string
-		initialHosts                                                   string
+		initialHosts, initialZone, initialZoneOrigin                   string
+		initialHostsManaged                                            bool
+		hideRegistryTXT                                                bool
+		stateDir, storeType, storeConfigMap                            string
+		raftID, raftAddr, raftSecret                                   string
+		raftPeers                                                      map[string]string
+		healthCheckInterval, healthCheckTimeout                        string
+		domainFilter, excludeDomains                                   []string
+		zones, zoneNameservers                                         []string
+		transferTo, notify                                             []string
+		tsigSecrets                                                    map[string]string
+		dnssecDenial, dnssecKeyDir                                     string
+		dnssecKeys                                                     []string
+		regexDomainFilter, regexDomainExclusion                        string
 		verbosity                                                      int
 		cmd                                                            = &cobra.Command{
 			Use:           "webhook",
@@ -66,53 +78,174 @@ func NewWebhook(version string) *cobra.Command {
 					corednslog.Discard()
 				}
 
+				// The externaldns plugin logs to the default logger.
+				slog.SetDefault(log)
+
 				dnsCacheDuration, err := time.ParseDuration(dnsCache)
 				if err != nil {
 					return err
 				}
 
+				for _, d := range []string{dnsDefaultTTL, healthCheckInterval, healthCheckTimeout} {
+					if _, err := time.ParseDuration(d); err != nil {
+						return err
+					}
+				}
+
+				if storeType == coredns.StoreBolt && stateDir == "" {
+					return fmt.Errorf("--store=bolt requires --state-dir")
+				}
+
 				log.Info("DNS cache seconds " + fmt.Sprint(int(dnsCacheDuration.Seconds())))
+				log.Info("DNS default TTL " + dnsDefaultTTL)
 				log.Info("DNS forward servers " + strings.Join(dnsForwardServers, ", "))
 
-				f, err := os.CreateTemp("", "hosts-*")
-				if err != nil {
-					return err
+				// The DNS server and the webhook API are both run by the
+				// externaldns plugin, configured from flags through its
+				// properties. See coredns/README.md.
+				properties := [][]string{
+					{"listen", fmt.Sprintf(":%d", port)},
+					{"max_answers", fmt.Sprint(dnsMaxAnswers)},
+					{"default_ttl", dnsDefaultTTL},
+					{"health_check_interval", healthCheckInterval},
+					{"health_check_timeout", healthCheckTimeout},
+					{"store", storeType},
 				}
-				defer os.Remove(f.Name())
-
-				log.Info("hosts file " + f.Name())
 
-				var g io.Reader = new(bytes.Buffer)
 				if initialHosts != "" {
-					h, err := xurl.OpenContext(ctx, initialHosts)
-					if err != nil {
-						return err
+					properties = append(properties, []string{"hosts", initialHosts})
+				}
+
+				if initialZone != "" {
+					properties = append(properties, []string{"zonefile", initialZone, initialZoneOrigin})
+				}
+
+				if initialHostsManaged {
+					properties = append(properties, []string{"hosts_managed"})
+				}
+
+				if hideRegistryTXT {
+					properties = append(properties, []string{"hide_registry_txt"})
+				}
+
+				if stateDir != "" {
+					properties = append(properties, []string{"state_dir", stateDir})
+				}
+
+				switch storeType {
+				case coredns.StoreConfigMap:
+					properties = append(properties, []string{"configmap", storeConfigMap})
+				case coredns.StoreRaft:
+					peers := []string{"raft_peers"}
+					for id, addr := range raftPeers {
+						peers = append(peers, id+"="+addr)
 					}
-					defer h.Close()
 
-					log.Info("opened initial hosts " + initialHosts)
+					properties = append(properties,
+						[]string{"raft_id", raftID},
+						[]string{"raft_addr", raftAddr},
+						[]string{"raft_secret", raftSecret},
+						peers,
+					)
+				}
 
-					g = h
+				if len(domainFilter) > 0 {
+					properties = append(properties, append([]string{"domain_filter"}, domainFilter...))
 				}
 
-				h, err := hosts.Decode(g)
-				if err != nil {
-					return err
+				if len(excludeDomains) > 0 {
+					properties = append(properties, append([]string{"exclude_domains"}, excludeDomains...))
 				}
 
-				log.Info("parsed initial hosts", "len", len(h.Hosts))
+				if regexDomainFilter != "" {
+					properties = append(properties, []string{"regex_domain_filter", regexDomainFilter})
+				}
 
-				if err := h.Encode(f); err != nil {
-					return err
+				if regexDomainExclusion != "" {
+					properties = append(properties, []string{"regex_domain_exclusion", regexDomainExclusion})
 				}
 
-				log.Info("wrote initial hosts to " + f.Name())
+				// Zones are answered from a server block of their own that
+				// shares the webhook but not the cache, which would cap the
+				// TTLs of zone transfers at a few seconds.
+				zoneProperties := [][]string{
+					{"max_answers", fmt.Sprint(dnsMaxAnswers)},
+					append([]string{"zone"}, zones...),
+				}
 
-				if err = f.Close(); err != nil {
-					return err
+				if len(zoneNameservers) > 0 {
+					zoneProperties = append(zoneProperties, append([]string{"nameservers"}, zoneNameservers...))
+				}
+
+				if len(notify) > 0 {
+					zoneProperties = append(zoneProperties, append([]string{"notify"}, notify...))
+				}
+
+				// Zones are signed with the given keys or with ones
+				// generated in the key or state directory.
+				if dnssecDenial != "" {
+					if len(zones) == 0 {
+						return fmt.Errorf("--dnssec requires --zone")
+					}
+
+					zoneProperties = append(zoneProperties, []string{"dnssec", dnssecDenial})
+
+					if len(dnssecKeys) > 0 {
+						zoneProperties = append(zoneProperties, append([]string{"dnssec_keys"}, dnssecKeys...))
+					}
+
+					if dnssecKeyDir == "" && stateDir != "" {
+						dnssecKeyDir = filepath.Join(stateDir, "keys")
+					}
+
+					if dnssecKeyDir != "" {
+						zoneProperties = append(zoneProperties, []string{"dnssec_key_dir", dnssecKeyDir})
+					}
 				}
 
-				if _, err = caddy.Start(caddy.CaddyfileInput{
+				// Zone transfers are answered by the transfer plugin
+				// through the externaldns plugin, signed by the tsig plugin.
+				zone := new(strings.Builder)
+				if len(zones) > 0 {
+					if len(zoneNameservers) == 0 {
+						return fmt.Errorf("--zone requires --zone-nameservers")
+					}
+
+					properties = append(properties, []string{"register", "webhook"})
+
+					for _, z := range zones {
+						zone.WriteString(quote(z) + " ")
+					}
+					zone.WriteString(fmt.Sprintf("{\n  prometheus :%d\n  header {\n    response set ra\n  }\n", dnsMetricsPort))
+
+					if len(transferTo) > 0 && len(tsigSecrets) > 0 {
+						zone.WriteString("  tsig {\n")
+						for name, secret := range tsigSecrets {
+							zone.WriteString("    secret " + quote(dns.Fqdn(name)) + " " + quote(secret) + "\n")
+						}
+						zone.WriteString("    require AXFR IXFR\n  }\n")
+					}
+
+					zone.WriteString("  externaldns webhook {\n" + block(zoneProperties) + "  }\n")
+
+					if len(transferTo) > 0 {
+						zone.WriteString("  transfer {\n    to")
+						for _, to := range transferTo {
+							zone.WriteString(" " + quote(to))
+						}
+						zone.WriteString("\n  }\n")
+					}
+
+					zone.WriteString("  loadbalance\n}\n")
+				} else if len(transferTo) > 0 {
+					return fmt.Errorf("--transfer-to requires --zone")
+				} else if len(notify) > 0 {
+					return fmt.Errorf("--notify requires --zone")
+				} else if len(zoneNameservers) > 0 {
+					return fmt.Errorf("--zone-nameservers requires --zone")
+				}
+
+				inst, err := caddy.Start(caddy.CaddyfileInput{
 					Filepath:       "Corefile",
 					ServerTypeName: "dns",
 					Contents: []byte(fmt.Sprintf(
@@ -125,35 +258,34 @@ func NewWebhook(version string) *cobra.Command {
   header {
     response set ra
   }
-  hosts %s {
-    fallthrough
-  }
+  externaldns {
+%s  }
   forward . %s
   cache %d
   loop
   loadbalance
 }
-`,
+
+%s`,
 						dnsReadyPort,
 						dnsHealthPort,
 						dnsMetricsPort,
-						f.Name(),
+						block(properties),
 						strings.Join(dnsForwardServers, " "),
 						int(dnsCacheDuration.Seconds()),
+						zone.String(),
 					)),
-				}); err != nil {
+				})
+				if err != nil {
 					return err
 				}
-				defer caddy.Stop() //nolint:errcheck
-
-				var (
-					startedC = make(chan struct{})
-					started  bool
-				)
-
-				go func() {
-					<-startedC
-					started = true
+				defer func() {
+					_ = caddy.Stop()
+					// Stopping does not run shutdown callbacks, which
+					// is where the externaldns plugin stops its webhook.
+					for _, err := range inst.ShutdownCallbacks() {
+						log.Error("shutdown", "err", err)
+					}
 				}()
 
 				l, err := net.Listen("tcp", metricsAddr)
@@ -164,11 +296,9 @@ func NewWebhook(version string) *cobra.Command {
 
 				mux := http.NewServeMux()
 
+				// By the time that caddy.Start returns, the
+				// webhook API is being served, too.
 				z := func(w http.ResponseWriter, r *http.Request) {
-					if !started {
-						w.WriteHeader(http.StatusInternalServerError)
-						return
-					}
 					fmt.Fprintln(w, "ok")
 				}
 				mux.HandleFunc("GET /readyz", z)
@@ -195,17 +325,6 @@ func NewWebhook(version string) *cobra.Command {
 					return errors.Join(srv.Shutdown(cctx), ctx.Err())
 				})
 
-				api.StartHTTPApi(
-					&externaldns.HostsFileProvider{
-						Hosts: h,
-						File:  f.Name(),
-					},
-					startedC,
-					time.Second*5,
-					0,
-					fmt.Sprintf(":%d", port),
-				)
-
 				return eg.Wait()
 			},
 		}
@@ -223,12 +342,70 @@ func NewWebhook(version string) *cobra.Command {
 	cmd.Flags().IntVar(&dnsReadyPort, "dns-ready-port", 9153, "DNS ready port")
 
 	cmd.Flags().StringVar(&dnsCache, "dns-cache", "30s", "DNS cache time")
+	cmd.Flags().StringVar(&dnsDefaultTTL, "dns-default-ttl", "1h", "DNS TTL of records that do not specify one")
+	cmd.Flags().IntVar(&dnsMaxAnswers, "dns-max-answers", coredns.DefaultMaxAnswers, "DNS maximum number of answers to multi-value records")
 	cmd.Flags().StringSliceVar(&dnsForwardServers, "dns-forward-server", []string{"1.1.1.2", "1.1.1.1", "8.8.8.8", "8.8.4.4"}, "DNS servers to forward to after fallthrough")
 
+	cmd.Flags().StringSliceVar(&zones, "zone", nil, "DNS zones to answer for authoritatively rather than forwarding names in them that have no records")
+	cmd.Flags().StringSliceVar(&zoneNameservers, "zone-nameservers", nil, "DNS names of the nameservers of --zone, which must have addresses, e.g. records from external-dns; required with --zone")
+
+	cmd.Flags().StringSliceVar(&transferTo, "transfer-to", nil, "Addresses of the secondary DNS servers allowed to transfer --zone, or * for any")
+	cmd.Flags().StringSliceVar(&notify, "notify", nil, "Addresses of the secondary DNS servers to send NOTIFY messages to when --zone changes, e.g. 10.0.0.2 or 10.0.0.2:5353")
+	cmd.Flags().StringToStringVar(&tsigSecrets, "tsig-secret", nil, "TSIG key names and base64 secrets that transfers must be signed with, e.g. transfer.key.=NoTCJU...")
+
+	cmd.Flags().StringVar(&dnssecDenial, "dnssec", "", "Sign --zone with DNSSEC, denying the existence of records with nsec or nsec3")
+	cmd.Flags().StringSliceVar(&dnssecKeys, "dnssec-keys", nil, "DNSSEC key files of --zone, e.g. Kfrantj.cc.+013+12345, generating keys in --dnssec-key-dir for the rest")
+	cmd.Flags().StringVar(&dnssecKeyDir, "dnssec-key-dir", "", "Directory to keep generated DNSSEC keys in, <state-dir>/keys if unset")
+
 	cmd.Flags().StringVar(&initialHosts, "init-hosts", "", "Initial hosts file")
+	cmd.Flags().StringVar(&initialZone, "init-zone", "", "Initial zone file")
+	cmd.Flags().StringVar(&initialZoneOrigin, "init-zone-origin", ".", "Origin of relative names in --init-zone that has no $ORIGIN")
+	cmd.Flags().BoolVar(&initialHostsManaged, "init-hosts-managed", false, "Let external-dns update and delete records from the initial hosts and zone files rather than treating them as read-only, seeding the store with them only if it is empty")
+	cmd.Flags().StringVar(&stateDir, "state-dir", "", "Directory to persist records to across restarts")
+	cmd.Flags().StringVar(&storeType, "store", "hosts", "Store to keep records in, one of hosts, bolt (requires --state-dir), configmap or raft")
+	cmd.Flags().StringVar(&storeConfigMap, "store-configmap", "external-dns-dnsserver-webhook", "Name of the ConfigMap in the current namespace to keep records in with --store=configmap")
+	hostname, _ := os.Hostname()
+	cmd.Flags().StringVar(&raftID, "raft-id", hostname, "ID of this node in --raft-peers with --store=raft")
+	cmd.Flags().StringVar(&raftAddr, "raft-addr", ":7000", "Address to listen on for other Raft nodes with --store=raft")
+	cmd.Flags().StringToStringVar(&raftPeers, "raft-peers", nil, "Raft nodes' IDs and addresses, including this one's, e.g. a=10.0.0.1:7000,b=10.0.0.2:7000 with --store=raft")
+	cmd.Flags().StringVar(&raftSecret, "raft-secret", "", "Secret shared by the Raft nodes with --store=raft, without which connections to --raft-addr are dropped")
+	cmd.Flags().BoolVar(&hideRegistryTXT, "hide-registry-txt", false, "Store external-dns TXT registry records without serving them")
+
+	cmd.Flags().StringVar(&healthCheckInterval, "health-check-interval", health.DefaultInterval.String(), "Time between health checks of records with a health check")
+	cmd.Flags().StringVar(&healthCheckTimeout, "health-check-timeout", health.DefaultTimeout.String(), "Timeout of health checks of records with a health check")
+
+	cmd.Flags().StringSliceVar(&domainFilter, "domain-filter", nil, "Limit records to those in the given domains")
+	cmd.Flags().StringSliceVar(&excludeDomains, "exclude-domains", nil, "Exclude records in the given domains")
+	cmd.Flags().StringVar(&regexDomainFilter, "regex-domain-filter", "", "Limit records to those matching the given regular expression, overriding --domain-filter")
+	cmd.Flags().StringVar(&regexDomainExclusion, "regex-domain-exclusion", "", "Exclude records matching the given regular expression, overriding --exclude-domains")
 
 	cmd.Flags().IntVar(&metricsPort, "metrics-port", 8080, "Metrics port")
 	cmd.Flags().IntVar(&port, "port", 8888, "Port")
 
+	cmd.AddCommand(NewDS())
+
 	return cmd
 }
+
+// block returns the properties of a plugin's block in a Corefile.
+func block(properties [][]string) string {
+	b := new(strings.Builder)
+	for _, property := range properties {
+		b.WriteString("    " + property[0])
+		for _, arg := range property[1:] {
+			b.WriteString(" " + quote(arg))
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// quote quotes s as a Corefile token if need be.
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'{}#\\") {
+		return s
+	}
+
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
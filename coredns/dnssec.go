@@ -0,0 +1,202 @@
+package coredns
+
+import (
+	"context"
+	"slices"
+
+	"github.com/coredns/coredns/request"
+	"github.com/frantjc/external-dns-dnsserver-webhook/dnssec"
+	"github.com/miekg/dns"
+	"k8s.io/utils/clock"
+)
+
+// dnssecTypes are the types of the DNSSEC records at the apex of signed Zones.
+var dnssecTypes = []uint16{dns.TypeDNSKEY, dns.TypeCDS, dns.TypeCDNSKEY, dns.TypeNSEC3PARAM}
+
+// signer returns the Signer of e.Keys.
+func (e *ExternalDNS) signer() *dnssec.Signer {
+	e.signerOnce.Do(func() {
+		e.dnssecSigner = dnssec.NewSigner(e.Keys...)
+	})
+
+	return e.dnssecSigner
+}
+
+// signed reports whether zone is signed, i.e. whether it has Keys.
+func (e *ExternalDNS) signed(zone string) bool {
+	return len(e.Keys) > 0 && len(e.signer().Keys(zone)) > 0
+}
+
+// clock returns e.Clock, the system clock if unset.
+func (e *ExternalDNS) clock() clock.Clock {
+	if e.Clock == nil {
+		return clock.RealClock{}
+	}
+
+	return e.Clock
+}
+
+// ResignSource is a TransferSource whose serial can
+// be advanced without changing its Endpoints.
+type ResignSource interface {
+	TransferSource
+	Touch()
+}
+
+// RunResign advances the serial of the Source every dnssec.ResignInterval
+// until ctx is done, if any of e.Zones are signed, so that secondaries
+// transfer them anew, with signatures made anew, before the signatures
+// that they have expire, even if their records do not change.
+func (e *ExternalDNS) RunResign(ctx context.Context) error {
+	src, ok := e.Source.(ResignSource)
+	if !ok || !slices.ContainsFunc(e.Zones, e.signed) {
+		return nil
+	}
+
+	clk := e.clock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clk.After(dnssec.ResignInterval):
+			src.Touch()
+		}
+	}
+}
+
+// dnskeys returns the DNSSEC records of type qtype at the apex of zone,
+// i.e. its DNSKEY records, their CDS and CDNSKEY records for the parent
+// to keep its DS records in sync with, see RFC 7344, and its NSEC3PARAM
+// record if e.NSEC3.
+func (e *ExternalDNS) dnskeys(zone string, qtype uint16) []dns.RR {
+	if !e.signed(zone) {
+		return nil
+	}
+
+	rrs := []dns.RR{}
+
+	for _, k := range e.signer().Keys(zone) {
+		var rr dns.RR
+
+		switch qtype {
+		case dns.TypeDNSKEY:
+			rr = dns.Copy(k.DNSKEY)
+		case dns.TypeCDS:
+			rr = k.CDS()
+		case dns.TypeCDNSKEY:
+			rr = k.CDNSKEY()
+		default:
+			continue
+		}
+
+		rr.Header().Ttl = zoneTTL
+		rrs = append(rrs, rr)
+	}
+
+	if qtype == dns.TypeNSEC3PARAM && e.NSEC3 {
+		rrs = append(rrs, dnssec.NSEC3PARAM(zone, zoneTTL))
+	}
+
+	return rrs
+}
+
+// sign adds the RRSIG records of the RRsets in signed Zones to m if the
+// request has the DO bit set. Negative answers from signed Zones also get
+// the NSEC or NSEC3 records that deny the existence of what was queried.
+// Signatures are cached by the contents of each RRset, so records that
+// change, e.g. through the webhook, are signed anew when next answered.
+func (e *ExternalDNS) sign(state request.Request, m *dns.Msg) error {
+	if len(e.Keys) == 0 || !state.Do() {
+		return nil
+	}
+
+	// Negative answers from Zones have their SOA
+	// record alone in the authority section.
+	if len(m.Ns) == 1 {
+		if soa, ok := m.Ns[0].(*dns.SOA); ok && e.signed(soa.Hdr.Name) {
+			name := state.Name()
+			for _, rr := range m.Answer {
+				if cname, ok := rr.(*dns.CNAME); ok {
+					name = cname.Target
+				}
+			}
+
+			m.Ns = append(m.Ns, e.deny(soa.Hdr.Name, name, m.Rcode == dns.RcodeNameError)...)
+
+			// NSEC "black lies" turn NXDOMAIN responses into NODATA ones.
+			if !e.NSEC3 {
+				m.Rcode = dns.RcodeSuccess
+			}
+		}
+	}
+
+	now := e.clock().Now()
+
+	for _, section := range []*[]dns.RR{&m.Answer, &m.Ns} {
+		sigs, err := e.signer().Sign(*section, now)
+		if err != nil {
+			return err
+		}
+
+		*section = append(*section, sigs...)
+	}
+
+	return nil
+}
+
+// deny returns the records that deny the existence of name in zone if
+// nxdomain, or of the records at name of any type that it does not have
+// otherwise. NSEC3 denials of a name's existence prove that its closest
+// encloser exists, while the next closer name and the wildcard at the
+// closest encloser do not, see RFC 5155 section 7.2.1.
+func (e *ExternalDNS) deny(zone, name string, nxdomain bool) []dns.RR {
+	switch {
+	case !e.NSEC3 && nxdomain:
+		return []dns.RR{dnssec.NSEC(name, zoneMinTTL, nil)}
+	case !e.NSEC3:
+		return []dns.RR{dnssec.NSEC(name, zoneMinTTL, e.types(zone, name))}
+	case !nxdomain:
+		return []dns.RR{dnssec.NSEC3(zone, name, zoneMinTTL, e.types(zone, name))}
+	}
+
+	encloser, nextCloser := zone, name
+	for i, end := dns.NextLabel(name, 0); !end; i, end = dns.NextLabel(name, i) {
+		if name[i:] == zone || (e.Source != nil && e.Source.Exists(name[i:])) {
+			encloser = name[i:]
+			break
+		}
+
+		nextCloser = name[i:]
+	}
+
+	return []dns.RR{
+		dnssec.NSEC3(zone, encloser, zoneMinTTL, e.types(zone, encloser)),
+		dnssec.NSEC3Cover(zone, nextCloser, zoneMinTTL),
+		dnssec.NSEC3Cover(zone, "*."+encloser, zoneMinTTL),
+	}
+}
+
+// types returns the types of the records that are answered for at name in zone.
+func (e *ExternalDNS) types(zone, name string) []uint16 {
+	types := []uint16{}
+
+	for _, ep := range e.lookup(name) {
+		switch t := dns.StringToType[ep.RecordType]; t {
+		case dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeTXT, dns.TypePTR:
+			types = append(types, t)
+		}
+	}
+
+	if name == zone {
+		types = append(types, dns.TypeSOA, dns.TypeNS)
+
+		for _, t := range dnssecTypes {
+			if len(e.dnskeys(zone, t)) > 0 {
+				types = append(types, t)
+			}
+		}
+	}
+
+	return types
+}
@@ -0,0 +1,228 @@
+package coredns_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/frantjc/external-dns-dnsserver-webhook/coredns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/dnssec"
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/miekg/dns"
+	testingclock "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestExternalDNSDNSSEC(t *testing.T) {
+	k, err := dnssec.GenerateKey("frantj.cc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := newExternalDNS(t,
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5", "10.0.0.6"),
+		endpoint.NewEndpoint("app.apps.frantj.cc", endpoint.RecordTypeA, "10.0.0.7"),
+	)
+	e.Zones = []string{"frantj.cc."}
+	e.Keys = []*dnssec.Key{k}
+
+	// Without the DO bit, nothing is signed.
+	if m := exchange(t, e, "www.frantj.cc", dns.TypeA); len(m.Answer) != 2 {
+		t.Fatalf("expected unsigned answer, got %v", m.Answer)
+	}
+
+	for _, qtype := range []uint16{dns.TypeDNSKEY, dns.TypeCDS, dns.TypeCDNSKEY} {
+		m := exchangeDO(t, e, "frantj.cc", qtype)
+		if len(m.Answer) != 2 || m.Answer[0].Header().Rrtype != qtype {
+			t.Fatalf("expected signed %s answer, got %v", dns.TypeToString[qtype], m.Answer)
+		}
+
+		verify(t, k, m.Answer)
+	}
+
+	m := exchangeDO(t, e, "www.frantj.cc", dns.TypeA)
+	if len(m.Answer) != 3 {
+		t.Fatalf("expected signed A answer, got %v", m.Answer)
+	}
+
+	verify(t, k, m.Answer)
+
+	// NSEC "black lies" answer NODATA for names that do not exist.
+	m = exchangeDO(t, e, "missing.frantj.cc", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess || len(m.Ns) != 4 {
+		t.Fatalf("expected signed NODATA answer, got %v", m)
+	}
+
+	verify(t, k, m.Ns)
+
+	if nsec, ok := m.Ns[1].(*dns.NSEC); !ok || !slices.Equal(nsec.TypeBitMap, []uint16{dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNXNAME}) {
+		t.Fatalf("expected NSEC denying every type and the name, got %v", m.Ns[1])
+	}
+
+	m = exchangeDO(t, e, "frantj.cc", dns.TypeA)
+	if nsec, ok := m.Ns[1].(*dns.NSEC); !ok || !slices.Equal(nsec.TypeBitMap, []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY, dns.TypeCDS, dns.TypeCDNSKEY}) {
+		t.Fatalf("expected NSEC with the types at the apex, got %v", m.Ns[1])
+	}
+
+	e.NSEC3 = true
+
+	if m = exchangeDO(t, e, "frantj.cc", dns.TypeNSEC3PARAM); len(m.Answer) != 2 {
+		t.Fatalf("expected signed NSEC3PARAM answer, got %v", m.Answer)
+	}
+
+	m = exchangeDO(t, e, "missing.apps.frantj.cc", dns.TypeA)
+	if m.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN, got %v", m)
+	}
+
+	verify(t, k, m.Ns)
+
+	// The closest encloser exists, while the next closer
+	// name and the wildcard at the closest encloser do not.
+	for _, proof := range []func(*dns.NSEC3) bool{
+		func(rr *dns.NSEC3) bool { return rr.Match("apps.frantj.cc.") },
+		func(rr *dns.NSEC3) bool {
+			return rr.Cover("missing.apps.frantj.cc.") && !rr.Match("missing.apps.frantj.cc.")
+		},
+		func(rr *dns.NSEC3) bool { return rr.Cover("*.apps.frantj.cc.") && !rr.Match("*.apps.frantj.cc.") },
+	} {
+		if !slices.ContainsFunc(m.Ns, func(rr dns.RR) bool {
+			nsec3, ok := rr.(*dns.NSEC3)
+			return ok && proof(nsec3)
+		}) {
+			t.Fatalf("expected closest encloser proof, got %v", m.Ns)
+		}
+	}
+
+	m = exchangeDO(t, e, "apps.frantj.cc", dns.TypeA)
+	if nsec3, ok := m.Ns[1].(*dns.NSEC3); m.Rcode != dns.RcodeSuccess || !ok || !nsec3.Match("apps.frantj.cc.") || len(nsec3.TypeBitMap) != 0 {
+		t.Fatalf("expected NODATA for empty non-terminal, got %v", m)
+	}
+
+	// Records that change are signed anew.
+	if err := e.Source.(*externaldns.Provider).ApplyChanges(context.TODO(), &plan.Changes{
+		UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5", "10.0.0.6")},
+		UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.8")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if m = exchangeDO(t, e, "www.frantj.cc", dns.TypeA); len(m.Answer) != 2 {
+		t.Fatalf("expected signed A answer, got %v", m.Answer)
+	}
+
+	verify(t, k, m.Answer)
+}
+
+func TestExternalDNSResign(t *testing.T) {
+	k, err := dnssec.GenerateKey("frantj.cc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		clk = testingclock.NewFakeClock(time.Now())
+		e   = newExternalDNS(t, endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"))
+		src = e.Source.(*externaldns.Provider)
+	)
+	e.Zones = []string{"frantj.cc."}
+	e.Keys = []*dnssec.Key{k}
+	e.Clock = clk
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go e.RunResign(ctx) //nolint:errcheck
+
+	// Without changes to its records, the serial of the zone still
+	// advances so that secondaries transfer it with signatures that
+	// are valid for as long as they would otherwise have had it.
+	for range 10 {
+		serial := src.Serial()
+
+		for !clk.HasWaiters() {
+			time.Sleep(time.Millisecond)
+		}
+
+		clk.Step(dnssec.ResignInterval)
+
+		for range 100 {
+			if src.Serial() != serial {
+				break
+			}
+
+			time.Sleep(time.Millisecond * 10)
+		}
+
+		if src.Serial() == serial {
+			t.Fatalf("expected serial to advance from %d", serial)
+		}
+
+		ch, err := e.Transfer("frantj.cc.", serial)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for rrs := range ch {
+			for _, rr := range rrs {
+				if sig, ok := rr.(*dns.RRSIG); ok && !sig.ValidityPeriod(clk.Now().Add(dnssec.ResignInterval)) {
+					t.Fatalf("expected %v to be valid until the next serial", sig)
+				}
+			}
+		}
+	}
+}
+
+// exchangeDO is exchange with the DO bit set.
+func exchangeDO(t *testing.T, e *coredns.ExternalDNS, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+
+	r := new(dns.Msg)
+	r.SetQuestion(dns.Fqdn(name), qtype)
+	r.SetEdns0(4096, true)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := e.ServeDNS(context.TODO(), rec, r); err != nil {
+		t.Fatal(err)
+	}
+
+	return rec.Msg
+}
+
+// verify verifies the RRSIG records in rrs with k, of which there
+// must be one for each RRset.
+func verify(t *testing.T, k *dnssec.Key, rrs []dns.RR) {
+	t.Helper()
+
+	var (
+		rrsets = map[string][]dns.RR{}
+		sigs   = []*dns.RRSIG{}
+	)
+
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs = append(sigs, sig)
+			continue
+		}
+
+		key := rr.Header().Name + dns.TypeToString[rr.Header().Rrtype]
+		rrsets[key] = append(rrsets[key], rr)
+	}
+
+	if len(sigs) != len(rrsets) {
+		t.Fatalf("expected an RRSIG for each of %d RRsets, got %d", len(rrsets), len(sigs))
+	}
+
+	for _, sig := range sigs {
+		rrset := rrsets[sig.Hdr.Name+dns.TypeToString[sig.TypeCovered]]
+		if err := sig.Verify(k.DNSKEY, rrset); err != nil {
+			t.Fatalf("verify %v: %v", rrset, err)
+		} else if !sig.ValidityPeriod(time.Now()) {
+			t.Fatalf("expected %v to be valid now", sig)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+// Package coredns implements the externaldns CoreDNS plugin, which answers
+// queries from the Endpoints of an in-process external-dns provider, each
+// record with its own TTL.
+package coredns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	"github.com/frantjc/external-dns-dnsserver-webhook/dnssec"
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/miekg/dns"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+const (
+	// Name is the name of the plugin as used in a Corefile.
+	Name = "externaldns"
+	// DefaultTTL is the TTL of records whose Endpoint has none configured.
+	DefaultTTL = 3600
+	// maxChase is the maximum length of a CNAME chain that is followed.
+	maxChase = 8
+)
+
+// Source provides the Endpoints to answer queries from.
+type Source interface {
+	// Lookup returns the Endpoints whose DNSName is name.
+	Lookup(name string) []*endpoint.Endpoint
+	// Exists reports whether there are Endpoints whose
+	// DNSName is name or a subdomain of it.
+	Exists(name string) bool
+}
+
+// Upstream resolves names that a Source does not have.
+type Upstream interface {
+	Lookup(ctx context.Context, state request.Request, name string, typ uint16) (*dns.Msg, error)
+}
+
+// ExternalDNS is the plugin handler.
+type ExternalDNS struct {
+	Next     plugin.Handler
+	Source   Source
+	Upstream Upstream
+	// MaxAnswers caps the number of targets answered from
+	// multi-value Endpoints, DefaultMaxAnswers if unset.
+	MaxAnswers int
+	// Zones are the fully-qualified, lowercased zones that are
+	// answered for authoritatively, with a synthesized SOA and NS
+	// records at their apex, rather than passing the names in them
+	// that have no records on to the next plugin.
+	Zones []string
+	// Nameservers are the fully-qualified names of the nameservers
+	// of Zones, "ns.<zone>" if unset, which the Source must then
+	// have addresses for.
+	Nameservers []string
+	// Notify are the addresses of the secondaries of Zones
+	// to send NOTIFY messages to, see RunNotify.
+	Notify []string
+	// NotifyRetryInterval is the time before a NOTIFY that is not
+	// acknowledged is first retried, DefaultNotifyRetryInterval if unset.
+	NotifyRetryInterval time.Duration
+	// Keys sign the records of those of Zones that they belong
+	// to in answers to requests with the DO bit set, which are
+	// also published at the apex of each zone, see sign.
+	Keys []*dnssec.Key
+	// NSEC3 denies the existence of records in signed Zones
+	// with NSEC3 rather than NSEC records.
+	NSEC3 bool
+	// Clock tells the time that records are signed at and
+	// times RunResign, the system clock if unset.
+	Clock clock.Clock
+
+	signerOnce   sync.Once
+	dnssecSigner *dnssec.Signer
+}
+
+var _ plugin.Handler = &ExternalDNS{}
+
+// ServeDNS implements plugin.Handler.
+func (e *ExternalDNS) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	var (
+		state = request.Request{W: w, Req: r}
+		qname = state.Name()
+		zone  = e.zone(qname)
+		eps   = e.route(e.lookup(qname))
+		m     = new(dns.Msg)
+	)
+
+	if state.QType() == dns.TypeAXFR || state.QType() == dns.TypeIXFR {
+		// Transfers of Zones are answered by the transfer plugin
+		// through Transfer before they get here, if it is loaded.
+		if zone != "" {
+			return dns.RcodeRefused, nil
+		}
+
+		return plugin.NextOrFailure(e.Name(), e.Next, ctx, w, r)
+	}
+
+	if len(eps) == 0 && zone == "" {
+		return plugin.NextOrFailure(e.Name(), e.Next, ctx, w, r)
+	}
+
+	m.SetReply(r)
+	m.Authoritative = true
+
+	var apex []dns.RR
+	if qname == zone {
+		apex = e.apex(zone, state.QType())
+	}
+
+	switch {
+	case len(apex) > 0:
+		m.Answer = apex
+	case len(eps) == 0:
+		// Names in a zone are answered from here alone rather than
+		// passed on, so those that do not exist get an NXDOMAIN
+		// response, except for the apex and empty non-terminals.
+		if qname != zone && (e.Source == nil || !e.Source.Exists(qname)) {
+			m.Rcode = dns.RcodeNameError
+		}
+
+		m.Ns = []dns.RR{e.negativeSOA(zone)}
+	default:
+		if cname := cnameFrom(qname, eps); cname != nil {
+			m.Answer = []dns.RR{cname}
+
+			if state.QType() != dns.TypeCNAME {
+				loop, _ := ctx.Value(dnsserver.LoopKey{}).(int)
+				if loop > maxChase {
+					return dns.RcodeServerFailure, fmt.Errorf("CNAME loop for %s", qname)
+				}
+
+				answer, ns, rcode, err := e.chase(context.WithValue(ctx, dnsserver.LoopKey{}, loop+1), state, cname)
+				if err != nil {
+					return dns.RcodeServerFailure, err
+				}
+
+				m.Answer = append(m.Answer, answer...)
+				m.Ns = ns
+				m.Rcode = rcode
+			}
+		} else {
+			// Names that exist but have no records of the
+			// queried type get a NODATA response.
+			m.Answer = rrsFrom(qname, state.QType(), eps)
+
+			if len(m.Answer) == 0 && zone != "" {
+				m.Ns = []dns.RR{e.negativeSOA(zone)}
+			}
+		}
+	}
+
+	if err := e.sign(state, m); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+
+	if err := uncached(w).WriteMsg(m); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+
+	return dns.RcodeSuccess, nil
+}
+
+// Name implements plugin.Handler.
+func (e *ExternalDNS) Name() string {
+	return Name
+}
+
+// chase follows the CNAME chain starting at cname through the names
+// that e.Source has and resolves the rest of it through e.Upstream,
+// unless it ends in one of e.Zones, returning the answer, authority
+// and rcode for the end of the chain.
+func (e *ExternalDNS) chase(ctx context.Context, state request.Request, cname *dns.CNAME) ([]dns.RR, []dns.RR, int, error) {
+	var (
+		answer = []dns.RR{}
+		target = cname.Target
+	)
+
+	for range maxChase {
+		eps := e.route(e.lookup(target))
+		zone := e.zone(target)
+
+		if len(eps) == 0 {
+			if zone != "" {
+				rcode := dns.RcodeSuccess
+				if target != zone && (e.Source == nil || !e.Source.Exists(target)) {
+					rcode = dns.RcodeNameError
+				}
+
+				return answer, []dns.RR{e.negativeSOA(zone)}, rcode, nil
+			}
+
+			if e.Upstream == nil {
+				return answer, nil, dns.RcodeSuccess, nil
+			}
+
+			m, err := e.Upstream.Lookup(ctx, state, target, state.QType())
+			if err != nil {
+				return nil, nil, dns.RcodeServerFailure, err
+			} else if m == nil {
+				return answer, nil, dns.RcodeSuccess, nil
+			}
+
+			return append(answer, m.Answer...), nil, m.Rcode, nil
+		}
+
+		next := cnameFrom(target, eps)
+		if next == nil {
+			rrs := rrsFrom(target, state.QType(), eps)
+			if len(rrs) == 0 && zone != "" {
+				return answer, []dns.RR{e.negativeSOA(zone)}, dns.RcodeSuccess, nil
+			}
+
+			return append(answer, rrs...), nil, dns.RcodeSuccess, nil
+		}
+
+		answer = append(answer, next)
+		target = next.Target
+	}
+
+	return nil, nil, dns.RcodeServerFailure, fmt.Errorf("CNAME chain for %s is longer than %d", state.Name(), maxChase)
+}
+
+// lookup returns the Endpoints to answer for name from, which are those
+// of a wildcard as per RFC 4592 if name does not exist itself, i.e. those
+// of "*.<closest encloser>" if it exists.
+func (e *ExternalDNS) lookup(name string) []*endpoint.Endpoint {
+	if e.Source == nil {
+		return nil
+	}
+
+	if eps := e.Source.Lookup(name); len(eps) > 0 {
+		return eps
+	} else if e.Source.Exists(name) {
+		// name is an empty non-terminal.
+		return nil
+	}
+
+	for i, end := dns.NextLabel(name, 0); !end; i, end = dns.NextLabel(name, i) {
+		if encloser := name[i:]; e.Source.Exists(encloser) {
+			return e.Source.Lookup("*." + encloser)
+		}
+	}
+
+	return nil
+}
+
+// cnameFrom returns the CNAME record at name from eps, if any.
+func cnameFrom(name string, eps []*endpoint.Endpoint) *dns.CNAME {
+	for _, ep := range eps {
+		if ep.RecordType == endpoint.RecordTypeCNAME && len(ep.Targets) > 0 {
+			return &dns.CNAME{
+				Hdr:    header(name, dns.TypeCNAME, ep),
+				Target: dns.Fqdn(strings.ToLower(ep.Targets[0])),
+			}
+		}
+	}
+
+	return nil
+}
+
+// rrsFrom returns the records of type qtype at name from eps.
+func rrsFrom(name string, qtype uint16, eps []*endpoint.Endpoint) []dns.RR {
+	rrs := []dns.RR{}
+
+	for _, ep := range eps {
+		if ep.RecordType != dns.TypeToString[qtype] {
+			continue
+		}
+
+		for _, target := range ep.Targets {
+			switch qtype {
+			case dns.TypeA:
+				if ip := net.ParseIP(target).To4(); ip != nil {
+					rrs = append(rrs, &dns.A{
+						Hdr: header(name, qtype, ep),
+						A:   ip,
+					})
+				}
+			case dns.TypeAAAA:
+				if ip := net.ParseIP(target); ip != nil {
+					rrs = append(rrs, &dns.AAAA{
+						Hdr:  header(name, qtype, ep),
+						AAAA: ip,
+					})
+				}
+			case dns.TypeTXT:
+				rrs = append(rrs, &dns.TXT{
+					Hdr: header(name, qtype, ep),
+					Txt: externaldns.TXTStrings(target),
+				})
+			case dns.TypePTR:
+				rrs = append(rrs, &dns.PTR{
+					Hdr: header(name, qtype, ep),
+					Ptr: dns.Fqdn(strings.ToLower(target)),
+				})
+			}
+		}
+	}
+
+	return rrs
+}
+
+func header(name string, rrtype uint16, ep *endpoint.Endpoint) dns.RR_Header {
+	return dns.RR_Header{
+		Name:   dns.Fqdn(strings.ToLower(name)),
+		Rrtype: rrtype,
+		Class:  dns.ClassINET,
+		Ttl:    ttl(ep),
+	}
+}
+
+func ttl(ep *endpoint.Endpoint) uint32 {
+	if ep.RecordTTL.IsConfigured() {
+		return uint32(ep.RecordTTL)
+	}
+
+	return DefaultTTL
+}
@@ -0,0 +1,516 @@
+package coredns_test
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/cache"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/frantjc/external-dns-dnsserver-webhook/coredns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/hosts"
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
+	"github.com/miekg/dns"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+type upstream map[string]string
+
+func (u upstream) Lookup(_ context.Context, state request.Request, name string, typ uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetReply(state.Req)
+
+	if a, ok := u[name]; ok && typ == dns.TypeA {
+		rr, err := dns.NewRR(name + " 60 IN A " + a)
+		if err != nil {
+			return nil, err
+		}
+		m.Answer = []dns.RR{rr}
+	} else if !ok {
+		m.Rcode = dns.RcodeNameError
+	}
+
+	return m, nil
+}
+
+func newExternalDNS(t *testing.T, eps ...*endpoint.Endpoint) *coredns.ExternalDNS {
+	t.Helper()
+
+	p := &externaldns.Provider{
+		Store: &store.HostsFile{
+			File:  filepath.Join(t.TempDir(), "hosts"),
+			Hosts: &hosts.Hosts{},
+		},
+	}
+
+	if err := p.ApplyChanges(context.TODO(), &plan.Changes{Create: eps}); err != nil {
+		t.Fatal(err)
+	}
+
+	return &coredns.ExternalDNS{
+		Source: p,
+		Upstream: upstream{
+			"lb.example.com.": "10.0.0.5",
+		},
+		Next: test.NextHandler(dns.RcodeNameError, nil),
+	}
+}
+
+func exchange(t *testing.T, e *coredns.ExternalDNS, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+
+	r := new(dns.Msg)
+	r.SetQuestion(dns.Fqdn(name), qtype)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rcode, err := e.ServeDNS(context.TODO(), rec, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Msg == nil {
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		return m
+	}
+
+	return rec.Msg
+}
+
+func TestExternalDNSCNAME(t *testing.T) {
+	e := newExternalDNS(t,
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeCNAME, "app.frantj.cc"),
+		endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeCNAME, "lb.example.com"),
+	)
+
+	m := exchange(t, e, "www.frantj.cc", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected rcode %s, got %s", dns.RcodeToString[dns.RcodeSuccess], dns.RcodeToString[m.Rcode])
+	} else if !m.Authoritative {
+		t.Fatal("expected authoritative answer")
+	}
+
+	if err := test.Section(test.Case{
+		Answer: []dns.RR{
+			test.CNAME("www.frantj.cc. 3600 IN CNAME app.frantj.cc."),
+			test.CNAME("app.frantj.cc. 3600 IN CNAME lb.example.com."),
+			test.A("lb.example.com. 60 IN A 10.0.0.5"),
+		},
+	}, test.Answer, m.Answer); err != nil {
+		t.Fatal(err)
+	}
+
+	m = exchange(t, e, "www.frantj.cc", dns.TypeCNAME)
+	if err := test.Section(test.Case{
+		Answer: []dns.RR{
+			test.CNAME("www.frantj.cc. 3600 IN CNAME app.frantj.cc."),
+		},
+	}, test.Answer, m.Answer); err != nil {
+		t.Fatal(err)
+	}
+
+	if m = exchange(t, e, "lb.frantj.cc", dns.TypeA); m.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected fallthrough to next plugin, got %s", dns.RcodeToString[m.Rcode])
+	}
+}
+
+func TestExternalDNSCNAMEDangling(t *testing.T) {
+	e := newExternalDNS(t,
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeCNAME, "gone.example.com"),
+	)
+
+	m := exchange(t, e, "www.frantj.cc", dns.TypeA)
+	if m.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected rcode %s, got %s", dns.RcodeToString[dns.RcodeNameError], dns.RcodeToString[m.Rcode])
+	}
+
+	if err := test.Section(test.Case{
+		Answer: []dns.RR{
+			test.CNAME("www.frantj.cc. 3600 IN CNAME gone.example.com."),
+		},
+	}, test.Answer, m.Answer); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalDNSTXT(t *testing.T) {
+	long := strings.Repeat("a", 300)
+
+	e := newExternalDNS(t,
+		endpoint.NewEndpoint("a-www.frantj.cc", endpoint.RecordTypeTXT, `"heritage=external-dns,external-dns/owner=default"`),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeTXT, `"part one" "part \"two\""`, "v=spf1 -all", long),
+	)
+
+	m := exchange(t, e, "a-www.frantj.cc", dns.TypeTXT)
+	if err := test.Section(test.Case{
+		Answer: []dns.RR{
+			test.TXT(`a-www.frantj.cc. 3600 IN TXT "heritage=external-dns,external-dns/owner=default"`),
+		},
+	}, test.Answer, m.Answer); err != nil {
+		t.Fatal(err)
+	}
+
+	m = exchange(t, e, "www.frantj.cc", dns.TypeTXT)
+	if len(m.Answer) != 3 {
+		t.Fatalf("expected 3 TXT records, got %d", len(m.Answer))
+	}
+
+	for i, expected := range [][]string{
+		{"part one", `part \"two\"`},
+		{"v=spf1 -all"},
+		{long[:255], long[255:]},
+	} {
+		if actual := m.Answer[i].(*dns.TXT).Txt; !slices.Equal(actual, expected) {
+			t.Fatalf("expected TXT strings %q, got %q", expected, actual)
+		}
+	}
+
+	m = exchange(t, e, "a-www.frantj.cc", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 0 {
+		t.Fatalf("expected NODATA, got %s with %d answers", dns.RcodeToString[m.Rcode], len(m.Answer))
+	}
+
+	m = exchange(t, e, "www.frantj.cc", dns.TypeA)
+	if err := test.Section(test.Case{
+		Answer: []dns.RR{
+			test.A("www.frantj.cc. 3600 IN A 10.0.0.5"),
+		},
+	}, test.Answer, m.Answer); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalDNSTTL(t *testing.T) {
+	e := newExternalDNS(t,
+		endpoint.NewEndpointWithTTL("www.frantj.cc", endpoint.RecordTypeCNAME, 300, "app.frantj.cc"),
+		endpoint.NewEndpointWithTTL("app.frantj.cc", endpoint.RecordTypeA, 5, "10.0.0.5", "10.0.0.6"),
+		endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeAAAA, "fd00::5"),
+	)
+
+	m := exchange(t, e, "www.frantj.cc", dns.TypeA)
+	if err := test.Section(test.Case{
+		Answer: []dns.RR{
+			test.CNAME("www.frantj.cc. 300 IN CNAME app.frantj.cc."),
+			test.A("app.frantj.cc. 5 IN A 10.0.0.5"),
+			test.A("app.frantj.cc. 5 IN A 10.0.0.6"),
+		},
+	}, test.Answer, m.Answer); err != nil {
+		t.Fatal(err)
+	}
+
+	m = exchange(t, e, "app.frantj.cc", dns.TypeAAAA)
+	if err := test.Section(test.Case{
+		Answer: []dns.RR{
+			test.AAAA("app.frantj.cc. 3600 IN AAAA fd00::5"),
+		},
+	}, test.Answer, m.Answer); err != nil {
+		t.Fatal(err)
+	}
+
+	e.Source.(*externaldns.Provider).DefaultTTL = 120
+
+	m = exchange(t, e, "app.frantj.cc", dns.TypeAAAA)
+	if err := test.Section(test.Case{
+		Answer: []dns.RR{
+			test.AAAA("app.frantj.cc. 120 IN AAAA fd00::5"),
+		},
+	}, test.Answer, m.Answer); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// raWriter sets the RA bit of what it writes, as the header plugin does.
+type raWriter struct {
+	dns.ResponseWriter
+}
+
+func (w *raWriter) WriteMsg(m *dns.Msg) error {
+	m.RecursionAvailable = true
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+func TestExternalDNSUncached(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		e   = newExternalDNS(t, endpoint.NewEndpointWithTTL("www.frantj.cc", endpoint.RecordTypeA, 86400, "10.0.0.5"))
+		c   = cache.New()
+	)
+
+	// As in a Corefile with cache, header and externaldns.
+	c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		return e.ServeDNS(ctx, &raWriter{w}, r)
+	})
+
+	query := func() *dns.Msg {
+		r := new(dns.Msg)
+		r.SetQuestion("www.frantj.cc.", dns.TypeA)
+
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := c.ServeDNS(ctx, rec, r); err != nil {
+			t.Fatal(err)
+		}
+
+		return rec.Msg
+	}
+
+	if m := query(); !m.RecursionAvailable || len(m.Answer) != 1 || m.Answer[0].Header().Ttl != 86400 {
+		t.Fatalf("expected the record's own TTL with RA set, got %v", m)
+	}
+
+	if err := e.Source.(*externaldns.Provider).ApplyChanges(ctx, &plan.Changes{
+		UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpointWithTTL("www.frantj.cc", endpoint.RecordTypeA, 86400, "10.0.0.5")},
+		UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpointWithTTL("www.frantj.cc", endpoint.RecordTypeA, 86400, "10.0.0.6")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old answer was not cached, so the new one is served right away.
+	if m := query(); len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "10.0.0.6" {
+		t.Fatalf("expected 10.0.0.6, got %v", m)
+	}
+}
+
+func TestExternalDNSWildcard(t *testing.T) {
+	e := newExternalDNS(t,
+		endpoint.NewEndpoint("*.apps.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		endpoint.NewEndpoint("www.apps.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+		endpoint.NewEndpoint("a.deep.apps.frantj.cc", endpoint.RecordTypeA, "10.0.0.7"),
+		endpoint.NewEndpoint("*.cname.frantj.cc", endpoint.RecordTypeCNAME, "www.apps.frantj.cc"),
+	)
+
+	for _, tc := range []test.Case{
+		{
+			Qname: "foo.apps.frantj.cc.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("foo.apps.frantj.cc. 3600 IN A 10.0.0.5")},
+		},
+		{
+			Qname: "www.apps.frantj.cc.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.apps.frantj.cc. 3600 IN A 10.0.0.6")},
+		},
+		{
+			Qname: "foo.cname.frantj.cc.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.CNAME("foo.cname.frantj.cc. 3600 IN CNAME www.apps.frantj.cc."),
+				test.A("www.apps.frantj.cc. 3600 IN A 10.0.0.6"),
+			},
+		},
+		{
+			// Empty non-terminals are not matched by the wildcard.
+			Qname: "deep.apps.frantj.cc.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+		{
+			// The closest encloser is deep.apps.frantj.cc,
+			// which has no wildcard of its own.
+			Qname: "b.deep.apps.frantj.cc.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+		{
+			Qname: "foo.bar.apps.frantj.cc.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("foo.bar.apps.frantj.cc. 3600 IN A 10.0.0.5")},
+		},
+		{
+			Qname: "foo.apps.frantj.cc.", Qtype: dns.TypeAAAA,
+		},
+	} {
+		m := exchange(t, e, tc.Qname, tc.Qtype)
+		if m.Rcode != tc.Rcode {
+			t.Fatalf("expected rcode %s for %s, got %s", dns.RcodeToString[tc.Rcode], tc.Qname, dns.RcodeToString[m.Rcode])
+		}
+
+		if err := test.Section(tc, test.Answer, m.Answer); err != nil {
+			t.Fatal(tc.Qname, err)
+		}
+	}
+}
+
+func TestExternalDNSWeighted(t *testing.T) {
+	e := newExternalDNS(t,
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5", "10.0.0.6").
+			WithSetIdentifier("blue").
+			WithProviderSpecific(externaldns.WeightProperty, "1"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.7").
+			WithSetIdentifier("green").
+			WithProviderSpecific(externaldns.WeightProperty, "0"),
+		endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeA, "10.0.0.5").
+			WithSetIdentifier("blue").
+			WithProviderSpecific(externaldns.WeightProperty, "1"),
+		endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeA, "10.0.0.6").
+			WithSetIdentifier("green").
+			WithProviderSpecific(externaldns.WeightProperty, "1"),
+	)
+
+	for range 16 {
+		m := exchange(t, e, "www.frantj.cc", dns.TypeA)
+		if err := test.Section(test.Case{
+			Answer: []dns.RR{
+				test.A("www.frantj.cc. 3600 IN A 10.0.0.5"),
+				test.A("www.frantj.cc. 3600 IN A 10.0.0.6"),
+			},
+		}, test.Answer, m.Answer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := map[string]bool{}
+	for range 256 {
+		m := exchange(t, e, "app.frantj.cc", dns.TypeA)
+		if len(m.Answer) != 1 {
+			t.Fatalf("expected 1 answer, got %v", m.Answer)
+		}
+
+		seen[m.Answer[0].(*dns.A).A.String()] = true
+	}
+
+	if !seen["10.0.0.5"] || !seen["10.0.0.6"] {
+		t.Fatalf("expected both weighted records to be answered, got %v", seen)
+	}
+}
+
+func TestExternalDNSMultiValue(t *testing.T) {
+	e := newExternalDNS(t,
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5", "10.0.0.6").
+			WithSetIdentifier("blue").
+			WithProviderSpecific(externaldns.MultiValueProperty, "true"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.6", "10.0.0.7").
+			WithSetIdentifier("green").
+			WithProviderSpecific(externaldns.MultiValueProperty, "true"),
+	)
+
+	m := exchange(t, e, "www.frantj.cc", dns.TypeA)
+	if len(m.Answer) != 3 {
+		t.Fatalf("expected 3 answers, got %v", m.Answer)
+	}
+
+	e.MaxAnswers = 2
+
+	m = exchange(t, e, "www.frantj.cc", dns.TypeA)
+	if len(m.Answer) != 2 {
+		t.Fatalf("expected 2 answers, got %v", m.Answer)
+	}
+}
+
+func TestExternalDNSFailover(t *testing.T) {
+	e := newExternalDNS(t,
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5").
+			WithSetIdentifier("primary").
+			WithProviderSpecific(externaldns.FailoverProperty, externaldns.FailoverPrimary),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.6").
+			WithSetIdentifier("secondary").
+			WithProviderSpecific(externaldns.FailoverProperty, externaldns.FailoverSecondary),
+	)
+
+	m := exchange(t, e, "www.frantj.cc", dns.TypeA)
+	if err := test.Section(test.Case{
+		Answer: []dns.RR{test.A("www.frantj.cc. 3600 IN A 10.0.0.5")},
+	}, test.Answer, m.Answer); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalDNSPTR(t *testing.T) {
+	e := newExternalDNS(t,
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		endpoint.NewEndpointWithTTL("app.frantj.cc", endpoint.RecordTypeA, 60, "10.0.0.5"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeAAAA, "fd00::5"),
+		endpoint.NewEndpoint("*.apps.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+	)
+
+	m := exchange(t, e, "5.0.0.10.in-addr.arpa", dns.TypePTR)
+	if err := test.Section(test.Case{
+		Answer: []dns.RR{
+			test.PTR("5.0.0.10.in-addr.arpa. 60 IN PTR www.frantj.cc."),
+			test.PTR("5.0.0.10.in-addr.arpa. 60 IN PTR app.frantj.cc."),
+		},
+	}, test.Answer, m.Answer); err != nil {
+		t.Fatal(err)
+	}
+
+	m = exchange(t, e, "5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa", dns.TypePTR)
+	if err := test.Section(test.Case{
+		Answer: []dns.RR{
+			test.PTR("5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa. 3600 IN PTR www.frantj.cc."),
+		},
+	}, test.Answer, m.Answer); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wildcards have no name to point back to.
+	if m = exchange(t, e, "6.0.0.10.in-addr.arpa", dns.TypePTR); m.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected fallthrough to next plugin, got %s", dns.RcodeToString[m.Rcode])
+	}
+}
+
+func TestExternalDNSZone(t *testing.T) {
+	e := newExternalDNS(t,
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		endpoint.NewEndpoint("app.apps.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+		endpoint.NewEndpoint("lb.frantj.cc", endpoint.RecordTypeCNAME, "gone.frantj.cc"),
+	)
+	e.Zones = []string{"frantj.cc."}
+
+	m := exchange(t, e, "frantj.cc", dns.TypeSOA)
+	if !m.Authoritative || len(m.Answer) != 1 {
+		t.Fatalf("expected an authoritative SOA answer, got %v", m)
+	}
+
+	soa, ok := m.Answer[0].(*dns.SOA)
+	if !ok || soa.Ns != "ns.frantj.cc." || soa.Mbox != "hostmaster.frantj.cc." {
+		t.Fatalf("unexpected SOA %v", m.Answer[0])
+	}
+
+	m = exchange(t, e, "frantj.cc", dns.TypeNS)
+	if err := test.Section(test.Case{
+		Answer: []dns.RR{test.NS("frantj.cc. 3600 IN NS ns.frantj.cc.")},
+	}, test.Answer, m.Answer); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		qtype uint16
+		rcode int
+	}{
+		{"missing.frantj.cc", dns.TypeA, dns.RcodeNameError},
+		{"www.frantj.cc", dns.TypeAAAA, dns.RcodeSuccess},
+		{"apps.frantj.cc", dns.TypeA, dns.RcodeSuccess},
+		{"frantj.cc", dns.TypeA, dns.RcodeSuccess},
+		{"lb.frantj.cc", dns.TypeA, dns.RcodeNameError},
+	} {
+		m = exchange(t, e, tc.name, tc.qtype)
+		if m.Rcode != tc.rcode {
+			t.Fatalf("expected rcode %s for %s, got %s", dns.RcodeToString[tc.rcode], tc.name, dns.RcodeToString[m.Rcode])
+		} else if !m.Authoritative {
+			t.Fatalf("expected authoritative answer for %s", tc.name)
+		} else if len(m.Ns) != 1 || m.Ns[0].Header().Rrtype != dns.TypeSOA {
+			t.Fatalf("expected SOA in authority section for %s, got %v", tc.name, m.Ns)
+		} else if ttl := m.Ns[0].Header().Ttl; ttl != m.Ns[0].(*dns.SOA).Minttl {
+			// As per RFC 2308 section 3, for negative answers to be cached for it.
+			t.Fatalf("expected SOA TTL of its minimum for %s, got %d", tc.name, ttl)
+		}
+	}
+
+	// Names outside of the zone are still passed on.
+	if m = exchange(t, e, "missing.example.com", dns.TypeA); m.Rcode != dns.RcodeNameError || len(m.Ns) != 0 {
+		t.Fatalf("expected fallthrough to next plugin, got %v", m)
+	}
+
+	if err := e.Source.(*externaldns.Provider).ApplyChanges(context.TODO(), &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("new.frantj.cc", endpoint.RecordTypeA, "10.0.0.7")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	m = exchange(t, e, "frantj.cc", dns.TypeSOA)
+	if serial := m.Answer[0].(*dns.SOA).Serial; serial <= soa.Serial {
+		t.Fatalf("expected serial to increase from %d, got %d", soa.Serial, serial)
+	}
+}
@@ -0,0 +1,191 @@
+package coredns
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/internal/logutil"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// notifyAttempts is the number of times that a NOTIFY is sent
+	// to a secondary before giving up until the next change.
+	notifyAttempts = 5
+	// DefaultNotifyRetryInterval is the default of
+	// ExternalDNS.NotifyRetryInterval.
+	DefaultNotifyRetryInterval = time.Second
+)
+
+var (
+	// NotifiesSent counts the NOTIFY messages sent to each secondary by
+	// whether they were acknowledged ("success") or not ("failure").
+	NotifiesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "coredns",
+		Subsystem: "externaldns",
+		Name:      "notifies_sent_total",
+		Help:      "Counter of NOTIFY messages sent to secondaries by result.",
+	}, []string{"zone", "to", "result"})
+	// NotifySerial reports the serial of the last
+	// NOTIFY that each secondary acknowledged.
+	NotifySerial = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "coredns",
+		Subsystem: "externaldns",
+		Name:      "notify_serial",
+		Help:      "Serial of the last NOTIFY that a secondary acknowledged.",
+	}, []string{"zone", "to"})
+)
+
+// WatchSource is a TransferSource that signals changes to its Endpoints.
+type WatchSource interface {
+	TransferSource
+	Watch(ctx context.Context) (<-chan struct{}, error)
+}
+
+// RunNotify sends NOTIFY messages for each of e.Zones to e.Notify when it
+// starts and whenever the records in it change thereafter, or its serial
+// does if it is signed, until ctx is done. NOTIFYs that are not acknowledged are retried with backoff.
+func (e *ExternalDNS) RunNotify(ctx context.Context) error {
+	src, ok := e.Source.(WatchSource)
+	if !ok || len(e.Zones) == 0 || len(e.Notify) == 0 {
+		return nil
+	}
+
+	watch, err := src.Watch(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg     sync.WaitGroup
+		queues = map[string][]chan struct{}{}
+		serial uint32
+	)
+
+	for _, zone := range e.Zones {
+		for _, to := range e.Notify {
+			// Signal each queue right away to notify on start.
+			q := make(chan struct{}, 1)
+			q <- struct{}{}
+			queues[zone] = append(queues[zone], q)
+
+			wg.Go(func() {
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-q:
+					}
+
+					e.notify(ctx, zone, to)
+				}
+			})
+		}
+	}
+
+	if v := src.Version(); v != nil {
+		serial = v.Serial
+	}
+
+	for range watch {
+		v := src.Version()
+		if v == nil {
+			continue
+		}
+
+		deltas, ok := v.Since(serial)
+		for _, zone := range e.Zones {
+			// Signed zones are transferred anew whenever
+			// the serial changes, e.g. to be re-signed.
+			if ok && !e.signed(zone) && !changed(zone, deltas) {
+				continue
+			}
+
+			for _, q := range queues[zone] {
+				select {
+				case q <- struct{}{}:
+				default:
+				}
+			}
+		}
+
+		serial = v.Serial
+	}
+
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// notify sends a NOTIFY for zone to the secondary at to, retrying
+// with backoff until it is acknowledged or notifyAttempts is reached.
+func (e *ExternalDNS) notify(ctx context.Context, zone, to string) {
+	var (
+		log    = logutil.SloggerFrom(ctx).With("zone", zone, "to", to)
+		client = &dns.Client{Net: "udp", Timeout: 2 * time.Second}
+		wait   = e.NotifyRetryInterval
+	)
+
+	if wait <= 0 {
+		wait = DefaultNotifyRetryInterval
+	}
+
+	for attempt := 1; ; attempt++ {
+		var (
+			soa = e.soa(zone)
+			m   = new(dns.Msg)
+		)
+
+		m.SetNotify(zone)
+		m.Answer = []dns.RR{soa}
+
+		res, _, err := client.ExchangeContext(ctx, m, to)
+		if err == nil && res.Rcode != dns.RcodeSuccess {
+			err = fmt.Errorf("rcode %s", dns.RcodeToString[res.Rcode])
+		}
+
+		if err == nil {
+			NotifiesSent.WithLabelValues(zone, to, "success").Inc()
+			NotifySerial.WithLabelValues(zone, to).Set(float64(soa.Serial))
+			log.Info("notified secondary", "serial", soa.Serial, "attempt", attempt)
+			return
+		}
+
+		NotifiesSent.WithLabelValues(zone, to, "failure").Inc()
+
+		if attempt == notifyAttempts {
+			log.Error("failed to notify secondary", "serial", soa.Serial, "attempt", attempt, "err", err)
+			return
+		}
+
+		log.Warn("failed to notify secondary, retrying in "+wait.String(), "serial", soa.Serial, "attempt", attempt, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+	}
+}
+
+// changed reports whether deltas change any records in zone.
+func changed(zone string, deltas []externaldns.Delta) bool {
+	for _, delta := range deltas {
+		for _, ep := range slices.Concat(delta.Delete, delta.Add) {
+			if dns.IsSubDomain(zone, strings.ToLower(dns.Fqdn(ep.DNSName))) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,114 @@
+package coredns_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/coredns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// secondary records the serials of the NOTIFYs that it receives,
+// refusing the first one to make the sender retry.
+type secondary struct {
+	mu      sync.Mutex
+	serials []uint32
+}
+
+func (s *secondary) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	if r.Opcode != dns.OpcodeNotify || len(r.Answer) != 1 {
+		m.Rcode = dns.RcodeFormatError
+	} else if s.serials = append(s.serials, r.Answer[0].(*dns.SOA).Serial); len(s.serials) == 1 {
+		m.Rcode = dns.RcodeRefused
+	}
+
+	_ = w.WriteMsg(m)
+}
+
+func (s *secondary) received() []uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]uint32{}, s.serials...)
+}
+
+func TestExternalDNSNotify(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		sec = &secondary{}
+		srv = &dns.Server{PacketConn: pc, Handler: sec}
+		to  = pc.LocalAddr().String()
+	)
+
+	go srv.ActivateAndServe() //nolint:errcheck
+	defer srv.Shutdown()      //nolint:errcheck
+
+	e := newExternalDNS(t)
+	e.Zones = []string{"frantj.cc."}
+	e.Notify = []string{to}
+	e.NotifyRetryInterval = time.Millisecond * 10
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go e.RunNotify(ctx) //nolint:errcheck
+
+	waitForSerial := func(serial uint32, n int) {
+		t.Helper()
+
+		for range 100 {
+			if received := sec.received(); len(received) >= n && received[len(received)-1] == serial {
+				if len(received) != n {
+					t.Fatalf("expected %d NOTIFYs, got %v", n, received)
+				}
+
+				return
+			}
+
+			time.Sleep(time.Millisecond * 10)
+		}
+
+		t.Fatalf("expected NOTIFY for serial %d, got %v", serial, sec.received())
+	}
+
+	src := e.Source.(coredns.WatchSource)
+
+	// The NOTIFY on start is refused once and then retried.
+	waitForSerial(src.Version().Serial, 2)
+
+	for _, name := range []string{"www.frantj.io", "www.frantj.cc"} {
+		if err := e.Source.(*externaldns.Provider).ApplyChanges(ctx, &plan.Changes{
+			Create: []*endpoint.Endpoint{endpoint.NewEndpoint(name, endpoint.RecordTypeA, "10.0.0.5")},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Changes outside of the zone are not notified of.
+	waitForSerial(src.Version().Serial, 3)
+
+	if n := testutil.ToFloat64(coredns.NotifiesSent.WithLabelValues("frantj.cc.", to, "failure")); n != 1 {
+		t.Fatalf("expected 1 failed NOTIFY, got %v", n)
+	} else if n := testutil.ToFloat64(coredns.NotifiesSent.WithLabelValues("frantj.cc.", to, "success")); n != 2 {
+		t.Fatalf("expected 2 successful NOTIFYs, got %v", n)
+	} else if serial := testutil.ToFloat64(coredns.NotifySerial.WithLabelValues("frantj.cc.", to)); uint32(serial) != src.Version().Serial {
+		t.Fatalf("expected acknowledged serial %d, got %v", src.Version().Serial, serial)
+	}
+}
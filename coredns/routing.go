@@ -0,0 +1,149 @@
+package coredns
+
+import (
+	"math/rand/v2"
+	"slices"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// DefaultMaxAnswers is the maximum number of targets answered from
+// multi-value Endpoints if none is configured.
+const DefaultMaxAnswers = 8
+
+// route returns the Endpoints from eps to answer from. Of the weighted
+// Endpoints of each record type, one is picked at random in proportion
+// to its weight. The targets of the multi-value Endpoints of each record
+// type are answered together, shuffled and capped at e.MaxAnswers.
+// Of the failover Endpoints of each record type, the primary is answered
+// if there is one, the secondary otherwise, so that a Source leaving out
+// Endpoints without healthy targets fails over. Any other Endpoint is
+// answered as-is.
+func (e *ExternalDNS) route(eps []*endpoint.Endpoint) []*endpoint.Endpoint {
+	var (
+		routed     = []*endpoint.Endpoint{}
+		weighted   = map[string][]*endpoint.Endpoint{}
+		multiValue = map[string][]*endpoint.Endpoint{}
+		failover   = map[string][]*endpoint.Endpoint{}
+		types      = []string{}
+	)
+
+	for _, ep := range eps {
+		if _, ok, _ := externaldns.Weight(ep); ok {
+			weighted[ep.RecordType] = append(weighted[ep.RecordType], ep)
+		} else if ok, _ := externaldns.IsMultiValue(ep); ok {
+			multiValue[ep.RecordType] = append(multiValue[ep.RecordType], ep)
+		} else if role, _ := externaldns.Failover(ep); role != "" {
+			failover[ep.RecordType] = append(failover[ep.RecordType], ep)
+		} else {
+			routed = append(routed, ep)
+			continue
+		}
+
+		if !slices.Contains(types, ep.RecordType) {
+			types = append(types, ep.RecordType)
+		}
+	}
+
+	for _, recordType := range types {
+		if ep := pickWeighted(weighted[recordType]); ep != nil {
+			routed = append(routed, ep)
+		}
+
+		if ep := mergeMultiValue(multiValue[recordType], e.maxAnswers()); ep != nil {
+			routed = append(routed, ep)
+		}
+
+		if ep := pickFailover(failover[recordType]); ep != nil {
+			routed = append(routed, ep)
+		}
+	}
+
+	return routed
+}
+
+func (e *ExternalDNS) maxAnswers() int {
+	if e.MaxAnswers > 0 {
+		return e.MaxAnswers
+	}
+
+	return DefaultMaxAnswers
+}
+
+// pickWeighted returns one of eps at random in proportion to its weight.
+// Endpoints with a weight of 0 are only picked if all of them have one.
+func pickWeighted(eps []*endpoint.Endpoint) *endpoint.Endpoint {
+	if len(eps) == 0 {
+		return nil
+	}
+
+	var (
+		weights = make([]uint64, len(eps))
+		total   uint64
+	)
+
+	for i, ep := range eps {
+		weight, _, _ := externaldns.Weight(ep)
+		weights[i] = uint64(weight)
+		total += weights[i]
+	}
+
+	if total == 0 {
+		return eps[rand.IntN(len(eps))]
+	}
+
+	n := rand.Uint64N(total)
+	for i, weight := range weights {
+		if n < weight {
+			return eps[i]
+		}
+		n -= weight
+	}
+
+	return eps[len(eps)-1]
+}
+
+// pickFailover returns the primary of eps, if any, or else the secondary.
+func pickFailover(eps []*endpoint.Endpoint) *endpoint.Endpoint {
+	if len(eps) == 0 {
+		return nil
+	}
+
+	for _, ep := range eps {
+		if role, _ := externaldns.Failover(ep); role == externaldns.FailoverPrimary {
+			return ep
+		}
+	}
+
+	return eps[0]
+}
+
+// mergeMultiValue returns an Endpoint with the targets of all of eps,
+// shuffled and capped at max.
+func mergeMultiValue(eps []*endpoint.Endpoint, max int) *endpoint.Endpoint {
+	if len(eps) == 0 {
+		return nil
+	}
+
+	merged := eps[0].DeepCopy()
+	merged.Targets = endpoint.Targets{}
+
+	for _, ep := range eps {
+		for _, target := range ep.Targets {
+			if !slices.Contains(merged.Targets, target) {
+				merged.Targets = append(merged.Targets, target)
+			}
+		}
+	}
+
+	rand.Shuffle(len(merged.Targets), func(i, j int) {
+		merged.Targets[i], merged.Targets[j] = merged.Targets[j], merged.Targets[i]
+	})
+
+	if len(merged.Targets) > max {
+		merged.Targets = merged.Targets[:max]
+	}
+
+	return merged
+}
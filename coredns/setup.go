@@ -0,0 +1,511 @@
+package coredns
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	corednsparse "github.com/coredns/coredns/plugin/pkg/parse"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/pkg/upstream"
+	"github.com/frantjc/external-dns-dnsserver-webhook/dnssec"
+	"github.com/frantjc/external-dns-dnsserver-webhook/internal/logutil"
+	"github.com/miekg/dns"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+var (
+	sourcesMu sync.Mutex
+	sources   = map[string]Source{}
+)
+
+func init() {
+	plugin.Register(Name, setup)
+
+	// Answer before the hosts plugin so that, e.g., CNAMEs are
+	// not shadowed by fallthrough to forward.
+	if !slices.Contains(dnsserver.Directives, Name) {
+		if i := slices.Index(dnsserver.Directives, "hosts"); i >= 0 {
+			dnsserver.Directives = slices.Insert(dnsserver.Directives, i, Name)
+		} else {
+			dnsserver.Directives = append(dnsserver.Directives, Name)
+		}
+	}
+}
+
+// RegisterSource makes src available to Corefiles as `externaldns NAME`.
+func RegisterSource(name string, src Source) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	sources[name] = src
+}
+
+func setup(c *caddy.Controller) error {
+	e, w, err := parse(c)
+	if err != nil {
+		return plugin.Error(Name, err)
+	}
+
+	// Only the block that runs the webhook starts and stops it,
+	// not those that answer from it after it is registered.
+	if w != nil {
+		c.OnStartup(w.Start)
+		// Stop before the new instance starts so that it can
+		// open the same store and listen on the same address.
+		c.OnRestart(w.Stop)
+		c.OnRestartFailed(w.Start)
+		c.OnShutdown(w.Stop)
+	}
+
+	// The webhook that e answers from, if any, exports e.Zones.
+	if src, ok := e.Source.(*Webhook); ok && len(e.Zones) > 0 {
+		src.Servers = append(src.Servers, e)
+	}
+
+	// Notifying and re-signing start after the webhook, if
+	// any, has started and stop along with it, as above.
+	if len(e.Notify) > 0 {
+		run(c, "notifying", e.RunNotify)
+	}
+
+	if slices.ContainsFunc(e.Zones, e.signed) {
+		run(c, "re-signing", e.RunResign)
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		e.Next = next
+		return e
+	})
+
+	return nil
+}
+
+// run runs fn from when the server starts until it stops.
+func run(c *caddy.Controller, name string, fn func(context.Context) error) {
+	var (
+		log    = slog.Default()
+		cancel = func() {}
+		start  = func() error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(logutil.SloggerInto(context.Background(), log))
+
+			go func() {
+				if err := fn(ctx); err != nil && !errors.Is(err, context.Canceled) {
+					log.Error(name+" stopped", "err", err)
+				}
+			}()
+
+			return nil
+		}
+		stop = func() error {
+			cancel()
+			return nil
+		}
+	)
+
+	c.OnStartup(start)
+	c.OnRestart(stop)
+	c.OnRestartFailed(start)
+	c.OnShutdown(stop)
+}
+
+// parse returns the ExternalDNS configured by c and the Webhook
+// that it runs, if it runs its own rather than a registered source.
+func parse(c *caddy.Controller) (*ExternalDNS, *Webhook, error) {
+	var (
+		e = &ExternalDNS{
+			Upstream: upstream.New(),
+		}
+		w *Webhook
+	)
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, nil, plugin.ErrOnce
+		}
+		i++
+
+		args := c.RemainingArgs()
+
+		switch len(args) {
+		case 0:
+			// Without the name of a registered source,
+			// the plugin runs its own provider.
+			w = &Webhook{}
+			e.Source = w
+		case 1:
+			sourcesMu.Lock()
+			src, ok := sources[args[0]]
+			sourcesMu.Unlock()
+			if !ok {
+				return nil, nil, c.Errf("no source registered as '%s'", args[0])
+			}
+
+			e.Source = src
+		default:
+			return nil, nil, c.ArgErr()
+		}
+
+		var (
+			domainFilter, excludeDomains            []string
+			regexDomainFilter, regexDomainExclusion string
+			signed                                  bool
+			keyFiles                                []string
+			keyDir                                  string
+		)
+
+		for c.NextBlock() {
+			property := c.Val()
+			if !slices.Contains([]string{"max_answers", "zone", "nameservers", "notify", "dnssec", "dnssec_keys", "dnssec_key_dir"}, property) && w == nil {
+				return nil, nil, c.Errf("property '%s' cannot be used with a registered source", property)
+			}
+
+			args := c.RemainingArgs()
+
+			switch property {
+			case "max_answers":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n <= 0 {
+					return nil, nil, c.Errf("invalid max_answers '%s'", args[0])
+				}
+
+				e.MaxAnswers = n
+			case "zone":
+				if len(args) == 0 {
+					return nil, nil, c.ArgErr()
+				}
+
+				for _, arg := range args {
+					e.Zones = append(e.Zones, strings.ToLower(dns.Fqdn(arg)))
+				}
+			case "nameservers":
+				if len(args) == 0 {
+					return nil, nil, c.ArgErr()
+				}
+
+				for _, arg := range args {
+					e.Nameservers = append(e.Nameservers, strings.ToLower(dns.Fqdn(arg)))
+				}
+			case "notify":
+				if len(args) == 0 {
+					return nil, nil, c.ArgErr()
+				}
+
+				for _, arg := range args {
+					addr, err := corednsparse.HostPort(arg, transport.Port)
+					if err != nil {
+						return nil, nil, c.Errf("invalid notify address '%s': %v", arg, err)
+					}
+
+					e.Notify = append(e.Notify, addr)
+				}
+			case "dnssec":
+				if len(args) > 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				signed = true
+				if len(args) > 0 {
+					switch args[0] {
+					case "nsec":
+					case "nsec3":
+						e.NSEC3 = true
+					default:
+						return nil, nil, c.Errf("unknown denial of existence '%s'", args[0])
+					}
+				}
+			case "dnssec_keys":
+				if len(args) == 0 {
+					return nil, nil, c.ArgErr()
+				}
+
+				keyFiles = append(keyFiles, args...)
+			case "dnssec_key_dir":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				keyDir = args[0]
+			case "register":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				// Other server blocks, e.g. one for zones that must
+				// not be cached, can answer from the same webhook.
+				RegisterSource(args[0], w)
+			case "listen":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				w.Addr = args[0]
+			case "hosts":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				w.InitialHosts = args[0]
+			case "zonefile":
+				if len(args) == 0 || len(args) > 2 {
+					return nil, nil, c.ArgErr()
+				}
+
+				w.InitialZone = args[0]
+				if len(args) > 1 {
+					w.InitialZoneOrigin = args[1]
+				}
+			case "hosts_managed":
+				if len(args) != 0 {
+					return nil, nil, c.ArgErr()
+				}
+
+				w.InitialHostsManaged = true
+			case "hide_registry_txt":
+				if len(args) != 0 {
+					return nil, nil, c.ArgErr()
+				}
+
+				w.HideRegistryTXT = true
+			case "default_ttl":
+				d, err := parseDuration(c, property, args)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				w.DefaultTTL = endpoint.TTL(d.Seconds())
+			case "domain_filter":
+				if len(args) == 0 {
+					return nil, nil, c.ArgErr()
+				}
+
+				domainFilter = append(domainFilter, args...)
+			case "exclude_domains":
+				if len(args) == 0 {
+					return nil, nil, c.ArgErr()
+				}
+
+				excludeDomains = append(excludeDomains, args...)
+			case "regex_domain_filter":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				regexDomainFilter = args[0]
+			case "regex_domain_exclusion":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				regexDomainExclusion = args[0]
+			case "state_dir":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				w.StateDir = args[0]
+			case "store":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				switch args[0] {
+				case StoreHosts, StoreBolt, StoreConfigMap, StoreRaft:
+					w.Store = args[0]
+				default:
+					return nil, nil, c.Errf("unknown store '%s'", args[0])
+				}
+			case "configmap":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				w.ConfigMap = args[0]
+			case "raft_id":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				w.Raft.ID = args[0]
+			case "raft_addr":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				w.Raft.Addr = args[0]
+			case "raft_secret":
+				if len(args) != 1 {
+					return nil, nil, c.ArgErr()
+				}
+
+				w.Raft.Secret = args[0]
+			case "raft_peers":
+				if len(args) == 0 {
+					return nil, nil, c.ArgErr()
+				}
+
+				if w.Raft.Peers == nil {
+					w.Raft.Peers = map[string]string{}
+				}
+
+				for _, arg := range args {
+					id, addr, ok := strings.Cut(arg, "=")
+					if !ok || id == "" || addr == "" {
+						return nil, nil, c.Errf("invalid raft peer '%s', expected ID=ADDR", arg)
+					}
+
+					w.Raft.Peers[id] = addr
+				}
+			case "health_check_interval":
+				d, err := parseDuration(c, property, args)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				w.HealthCheckInterval = d
+			case "health_check_timeout":
+				d, err := parseDuration(c, property, args)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				w.HealthCheckTimeout = d
+			default:
+				return nil, nil, c.Errf("unknown property '%s'", property)
+			}
+		}
+
+		// The default nameserver, ns.<zone>, has no address to answer with.
+		if len(e.Zones) > 0 && len(e.Nameservers) == 0 {
+			return nil, nil, c.Err("zone requires nameservers")
+		}
+
+		if signed {
+			// Keys that are not given are generated
+			// and kept in the state directory.
+			if keyDir == "" && w != nil && w.StateDir != "" {
+				keyDir = filepath.Join(w.StateDir, "keys")
+			}
+
+			if err := loadKeys(c, e, keyFiles, keyDir); err != nil {
+				return nil, nil, err
+			}
+		} else if len(keyFiles) > 0 || keyDir != "" {
+			return nil, nil, c.Err("dnssec_keys and dnssec_key_dir require dnssec")
+		}
+
+		if w == nil {
+			continue
+		}
+
+		switch w.Store {
+		case StoreBolt:
+			if w.StateDir == "" {
+				return nil, nil, c.Errf("store '%s' requires state_dir", w.Store)
+			}
+		case StoreConfigMap:
+			if w.ConfigMap == "" {
+				return nil, nil, c.Errf("store '%s' requires configmap", w.Store)
+			}
+		case StoreRaft:
+			if w.Raft.ID == "" || w.Raft.Addr == "" || len(w.Raft.Peers) == 0 || w.Raft.Secret == "" {
+				return nil, nil, c.Errf("store '%s' requires raft_id, raft_addr, raft_peers and raft_secret", w.Store)
+			}
+		}
+
+		w.DomainFilter = endpoint.NewDomainFilterWithExclusions(domainFilter, excludeDomains)
+		if regexDomainFilter != "" || regexDomainExclusion != "" {
+			regex, err := regexp.Compile(regexDomainFilter)
+			if err != nil {
+				return nil, nil, c.Errf("invalid regex_domain_filter '%s': %v", regexDomainFilter, err)
+			}
+
+			regexExclusion, err := regexp.Compile(regexDomainExclusion)
+			if err != nil {
+				return nil, nil, c.Errf("invalid regex_domain_exclusion '%s': %v", regexDomainExclusion, err)
+			}
+
+			w.DomainFilter = endpoint.NewRegexDomainFilter(regex, regexExclusion)
+		}
+	}
+
+	return e, w, nil
+}
+
+// loadKeys reads the DNSSEC keys of e.Zones from files, generating those
+// of the Zones that have none in dir, if any, and logs the DS records to
+// publish in their parents.
+func loadKeys(c *caddy.Controller, e *ExternalDNS, files []string, dir string) error {
+	if len(e.Zones) == 0 {
+		return c.Err("dnssec requires zone")
+	}
+
+	log := slog.Default()
+
+	for _, file := range files {
+		k, err := dnssec.ReadKey(file)
+		if err != nil {
+			return c.Errf("invalid dnssec_keys '%s': %v", file, err)
+		} else if !slices.Contains(e.Zones, k.Zone()) {
+			return c.Errf("dnssec key '%s' is for %s, which is not a zone", file, k.Zone())
+		}
+
+		e.Keys = append(e.Keys, k)
+	}
+
+	for _, zone := range e.Zones {
+		if slices.ContainsFunc(e.Keys, func(k *dnssec.Key) bool {
+			return k.Zone() == zone
+		}) {
+			continue
+		}
+
+		if dir == "" {
+			return c.Errf("dnssec requires dnssec_keys, dnssec_key_dir or state_dir for zone '%s'", zone)
+		}
+
+		keys, generated, err := dnssec.ReadOrGenerateKeys(dir, zone)
+		if err != nil {
+			return c.Errf("dnssec keys for zone '%s': %v", zone, err)
+		} else if generated {
+			log.Info("generated DNSSEC key for "+zone, "dir", dir)
+		}
+
+		e.Keys = append(e.Keys, keys...)
+	}
+
+	for _, k := range e.Keys {
+		log.Info("DS record for the parent of "+k.Zone(), "ds", k.DS().String())
+	}
+
+	return nil
+}
+
+func parseDuration(c *caddy.Controller, property string, args []string) (time.Duration, error) {
+	if len(args) != 1 {
+		return 0, c.ArgErr()
+	}
+
+	d, err := time.ParseDuration(args[0])
+	if err != nil || d <= 0 {
+		return 0, c.Errf("invalid %s '%s'", property, args[0])
+	}
+
+	return d, nil
+}
@@ -0,0 +1,242 @@
+package coredns
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+func TestParse(t *testing.T) {
+	RegisterSource("test", &Webhook{})
+
+	tests := []struct {
+		input string
+		err   bool
+		check func(*testing.T, *ExternalDNS)
+	}{
+		{input: `externaldns test`},
+		{
+			input: `externaldns test {
+				max_answers 2
+			}`,
+			check: func(t *testing.T, e *ExternalDNS) {
+				if e.MaxAnswers != 2 {
+					t.Fatalf("expected max_answers 2, got %d", e.MaxAnswers)
+				}
+			},
+		},
+		{
+			input: `externaldns test {
+				zone Frantj.cc example.com.
+				nameservers ns1.frantj.cc
+				notify 10.0.0.2 10.0.0.3:5353
+			}`,
+			check: func(t *testing.T, e *ExternalDNS) {
+				if len(e.Zones) != 2 || e.Zones[0] != "frantj.cc." || e.Zones[1] != "example.com." {
+					t.Fatalf("unexpected zones %v", e.Zones)
+				} else if len(e.Nameservers) != 1 || e.Nameservers[0] != "ns1.frantj.cc." {
+					t.Fatalf("unexpected nameservers %v", e.Nameservers)
+				} else if len(e.Notify) != 2 || e.Notify[0] != "10.0.0.2:53" || e.Notify[1] != "10.0.0.3:5353" {
+					t.Fatalf("unexpected notify %v", e.Notify)
+				}
+			},
+		},
+		{
+			input: `externaldns test {
+				zone
+			}`,
+			err: true,
+		},
+		{
+			input: `externaldns test {
+				zone frantj.cc
+			}`,
+			err: true,
+		},
+		{
+			input: `externaldns test {
+				dnssec
+			}`,
+			err: true,
+		},
+		{
+			input: `externaldns test {
+				zone frantj.cc
+				nameservers ns1.frantj.cc
+				dnssec
+			}`,
+			err: true,
+		},
+		{
+			input: `externaldns test {
+				zone frantj.cc
+				nameservers ns1.frantj.cc
+				dnssec nsec5
+			}`,
+			err: true,
+		},
+		{
+			input: `externaldns test {
+				zone frantj.cc
+				nameservers ns1.frantj.cc
+				dnssec_key_dir /var/lib/dnsserver/keys
+			}`,
+			err: true,
+		},
+		{
+			input: `externaldns test {
+				zone frantj.cc
+				nameservers ns1.frantj.cc
+				dnssec
+				dnssec_keys /nonexistent/Kfrantj.cc.+013+12345
+			}`,
+			err: true,
+		},
+		{input: `externaldns missing`, err: true},
+		{input: `externaldns a b`, err: true},
+		{input: `externaldns test { listen :8888 }`, err: true},
+		{input: `externaldns { max_answers 0 }`, err: true},
+		{input: `externaldns { unknown }`, err: true},
+		{
+			input: `externaldns {
+				listen :8888
+				hosts /etc/hosts
+				zonefile /etc/bind/db.frantj.cc frantj.cc
+				hosts_managed
+				hide_registry_txt
+				default_ttl 5m
+				domain_filter frantj.cc example.com
+				exclude_domains internal.frantj.cc
+				state_dir /var/lib/dnsserver
+				store raft
+				raft_id a
+				raft_addr :7000
+				raft_peers a=10.0.0.1:7000 b=10.0.0.2:7000
+				raft_secret hunter2
+				health_check_interval 30s
+				health_check_timeout 2s
+			}`,
+			check: func(t *testing.T, e *ExternalDNS) {
+				w, ok := e.Source.(*Webhook)
+				if !ok {
+					t.Fatalf("expected a webhook source, got %T", e.Source)
+				}
+
+				switch {
+				case w.Addr != ":8888":
+					t.Fatalf("unexpected listen %q", w.Addr)
+				case w.InitialHosts != "/etc/hosts" || !w.InitialHostsManaged:
+					t.Fatalf("unexpected hosts %q", w.InitialHosts)
+				case w.InitialZone != "/etc/bind/db.frantj.cc" || w.InitialZoneOrigin != "frantj.cc":
+					t.Fatalf("unexpected zonefile %q %q", w.InitialZone, w.InitialZoneOrigin)
+				case !w.HideRegistryTXT:
+					t.Fatal("expected hide_registry_txt")
+				case w.DefaultTTL != 300:
+					t.Fatalf("unexpected default_ttl %d", w.DefaultTTL)
+				case !w.DomainFilter.Match("www.frantj.cc") || w.DomainFilter.Match("www.internal.frantj.cc") || w.DomainFilter.Match("frantj.io"):
+					t.Fatalf("unexpected domain filter %v", w.DomainFilter)
+				case w.StateDir != "/var/lib/dnsserver" || w.Store != StoreRaft:
+					t.Fatalf("unexpected store %q in %q", w.Store, w.StateDir)
+				case w.Raft.ID != "a" || w.Raft.Addr != ":7000" || len(w.Raft.Peers) != 2 || w.Raft.Peers["b"] != "10.0.0.2:7000" || w.Raft.Secret != "hunter2":
+					t.Fatalf("unexpected raft config %v", w.Raft)
+				case w.HealthCheckInterval != 30*time.Second || w.HealthCheckTimeout != 2*time.Second:
+					t.Fatalf("unexpected health checks %s %s", w.HealthCheckInterval, w.HealthCheckTimeout)
+				}
+			},
+		},
+		{
+			input: `externaldns {
+				regex_domain_filter ^.*\.frantj\.cc$
+			}`,
+			check: func(t *testing.T, e *ExternalDNS) {
+				if w := e.Source.(*Webhook); !w.DomainFilter.Match("www.frantj.cc") || w.DomainFilter.Match("www.frantj.io") {
+					t.Fatalf("unexpected domain filter %v", w.DomainFilter)
+				}
+			},
+		},
+		{input: `externaldns { regex_domain_filter ( }`, err: true},
+		{input: `externaldns { default_ttl forever }`, err: true},
+		{input: `externaldns { store etcd }`, err: true},
+		{input: `externaldns { store bolt }`, err: true},
+		{input: `externaldns { store configmap }`, err: true},
+		{input: `externaldns { store raft }`, err: true},
+		{input: `externaldns { raft_peers a }`, err: true},
+		{
+			input: `externaldns {
+				store configmap
+				configmap dnsserver
+			}`,
+		},
+		{
+			input: "externaldns\nexternaldns",
+			err:   true,
+		},
+		{
+			input: `externaldns {
+				register shared
+			}`,
+			check: func(t *testing.T, e *ExternalDNS) {
+				if sources["shared"] != e.Source {
+					t.Fatalf("expected the webhook to be registered as shared, got %v", sources["shared"])
+				}
+			},
+		},
+		{
+			input: `externaldns test {
+				register shared
+			}`,
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		e, _, err := parse(caddy.NewTestController("dns", tt.input))
+		if tt.err {
+			if err == nil {
+				t.Fatalf("expected error for %q", tt.input)
+			}
+
+			continue
+		} else if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.input, err)
+		}
+
+		if tt.check != nil {
+			tt.check(t, e)
+		}
+	}
+}
+
+func TestParseDNSSEC(t *testing.T) {
+	RegisterSource("test", &Webhook{})
+
+	stateDir := t.TempDir()
+
+	e, _, err := parse(caddy.NewTestController("dns", fmt.Sprintf(`externaldns {
+		zone frantj.cc
+		nameservers ns1.frantj.cc
+		dnssec nsec3
+		state_dir %s
+	}`, stateDir)))
+	if err != nil {
+		t.Fatal(err)
+	} else if !e.NSEC3 || len(e.Keys) != 1 || e.Keys[0].Zone() != "frantj.cc." {
+		t.Fatalf("expected a key to be generated for frantj.cc., got %v", e.Keys)
+	}
+
+	// The generated key is kept in the state directory,
+	// so it is read back rather than generated anew.
+	e2, _, err := parse(caddy.NewTestController("dns", fmt.Sprintf(`externaldns test {
+		zone frantj.cc
+		nameservers ns1.frantj.cc
+		dnssec
+		dnssec_keys %s/keys/%s
+	}`, stateDir, e.Keys[0].Name())))
+	if err != nil {
+		t.Fatal(err)
+	} else if e2.NSEC3 || len(e2.Keys) != 1 || e2.Keys[0].DS().String() != e.Keys[0].DS().String() {
+		t.Fatalf("expected key %s to be read, got %v", e.Keys[0].Name(), e2.Keys)
+	}
+}
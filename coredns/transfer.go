@@ -0,0 +1,106 @@
+package coredns
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/transfer"
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/miekg/dns"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// TransferSource is a Source whose Endpoints can be
+// transferred to secondary DNS servers, see Transfer.
+type TransferSource interface {
+	Source
+	Version() *externaldns.Version
+}
+
+var _ transfer.Transferer = &ExternalDNS{}
+
+// Transfer implements transfer.Transferer for e.Zones. IXFRs are answered
+// incrementally from the journal of the Source's Version so long as it
+// goes back as far as serial, falling back to an AXFR otherwise. Signed
+// Zones are always transferred in full, along with the RRSIG records of
+// each RRset and a chain of NSEC or NSEC3 records.
+func (e *ExternalDNS) Transfer(zone string, serial uint32) (<-chan []dns.RR, error) {
+	zone = strings.ToLower(dns.Fqdn(zone))
+	if !slices.Contains(e.Zones, zone) {
+		return nil, transfer.ErrNotAuthoritative
+	}
+
+	src, ok := e.Source.(TransferSource)
+	if !ok {
+		return nil, transfer.ErrNotAuthoritative
+	}
+
+	v := src.Version()
+	if v == nil {
+		return nil, transfer.ErrNotAuthoritative
+	}
+
+	var (
+		soa = e.soaWithSerial(zone, v.Serial)
+		// The secondary is up-to-date, by serial number arithmetic.
+		upToDate = serial != 0 && int32(v.Serial-serial) <= 0
+		axfr     []dns.RR
+		ch       = make(chan []dns.RR)
+	)
+
+	if e.signed(zone) && !upToDate {
+		var err error
+		if axfr, err = e.signer().SignZone(zone, e.axfr(zone, v), e.NSEC3, zoneMinTTL, e.clock().Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	go func() {
+		defer close(ch)
+
+		if upToDate {
+			ch <- []dns.RR{soa}
+			return
+		}
+
+		if serial != 0 && axfr == nil {
+			if deltas, ok := v.Since(serial); ok {
+				ch <- []dns.RR{soa}
+
+				for _, delta := range deltas {
+					ch <- append([]dns.RR{e.soaWithSerial(zone, delta.From)}, e.records(zone, delta.Delete)...)
+					ch <- append([]dns.RR{e.soaWithSerial(zone, delta.To)}, e.records(zone, delta.Add)...)
+				}
+
+				ch <- []dns.RR{soa}
+				return
+			}
+		}
+
+		if axfr == nil {
+			axfr = e.axfr(zone, v)
+		}
+
+		ch <- axfr
+		ch <- []dns.RR{soa}
+	}()
+
+	return ch, nil
+}
+
+// axfr returns every record of zone as of v, starting with its SOA record.
+func (e *ExternalDNS) axfr(zone string, v *externaldns.Version) []dns.RR {
+	rrs := append([]dns.RR{e.soaWithSerial(zone, v.Serial)}, e.ns(zone)...)
+
+	for _, t := range dnssecTypes {
+		rrs = append(rrs, e.dnskeys(zone, t)...)
+	}
+
+	return append(rrs, e.records(zone, v.Endpoints)...)
+}
+
+// records returns the records of the Endpoints in zone, including every
+// alternative of those with a routing policy.
+func (e *ExternalDNS) records(zone string, eps []*endpoint.Endpoint) []dns.RR {
+	return externaldns.ZoneFromEndpoints(zone, DefaultTTL, eps).Records
+}
@@ -0,0 +1,226 @@
+package coredns_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ctest "github.com/coredns/coredns/test"
+	"github.com/frantjc/external-dns-dnsserver-webhook/coredns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/dnssec"
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/hosts"
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
+	"github.com/miekg/dns"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+const (
+	tsigName   = "transfer.key."
+	tsigSecret = "NoTCJU+DMqFWywaPyxSijrDEA/eC3nK0xi3AMEZuPVk="
+)
+
+func startTransferServer(t *testing.T, to string) string {
+	t.Helper()
+
+	i, _, tcp, err := ctest.CoreDNSServerAndPorts(`.:0 {
+    tsig {
+        secret ` + tsigName + ` ` + tsigSecret + `
+        require AXFR IXFR
+    }
+    externaldns transfer {
+        zone frantj.cc
+        nameservers ns.frantj.cc
+    }
+    transfer {
+        to ` + to + `
+    }
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ctest.CoreDNSServerStop(i) })
+
+	// Connect over IPv4 for the address to match those in to.
+	_, port, err := net.SplitHostPort(tcp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+func transferIn(t *testing.T, addr string, m *dns.Msg, sign bool) ([]dns.RR, error) {
+	t.Helper()
+
+	tr := new(dns.Transfer)
+	if sign {
+		tr.TsigSecret = map[string]string{tsigName: tsigSecret}
+		m.SetTsig(tsigName, dns.HmacSHA256, 300, time.Now().Unix())
+	}
+
+	envs, err := tr.In(m, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	rrs := []dns.RR{}
+	for env := range envs {
+		if env.Error != nil {
+			return nil, env.Error
+		}
+
+		rrs = append(rrs, env.RR...)
+	}
+
+	return rrs, nil
+}
+
+func TestTransfer(t *testing.T) {
+	p := &externaldns.Provider{
+		Store: &store.HostsFile{
+			File:  filepath.Join(t.TempDir(), "hosts"),
+			Hosts: &hosts.Hosts{},
+		},
+	}
+
+	if err := p.ApplyChanges(context.TODO(), &plan.Changes{Create: []*endpoint.Endpoint{
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		endpoint.NewEndpoint("www.frantj.io", endpoint.RecordTypeA, "10.0.0.6"),
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	coredns.RegisterSource("transfer", p)
+
+	addr := startTransferServer(t, "127.0.0.1")
+
+	axfr := new(dns.Msg)
+	axfr.SetAxfr("frantj.cc.")
+
+	if _, err := transferIn(t, addr, axfr.Copy(), false); err == nil {
+		t.Fatal("expected unsigned transfer to be refused")
+	}
+
+	rrs, err := transferIn(t, addr, axfr.Copy(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SOA, NS, A, SOA, leaving out www.frantj.io and the PTR for it.
+	if len(rrs) != 4 {
+		t.Fatalf("expected 4 records, got %d: %v", len(rrs), rrs)
+	}
+
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok || rrs[1].Header().Rrtype != dns.TypeNS || rrs[2].String() != "www.frantj.cc.\t3600\tIN\tA\t10.0.0.5" {
+		t.Fatalf("unexpected records %v", rrs)
+	} else if last, ok := rrs[3].(*dns.SOA); !ok || last.Serial != soa.Serial {
+		t.Fatalf("expected transfer to end with the SOA, got %v", rrs[3])
+	}
+
+	ixfr := new(dns.Msg)
+	ixfr.SetIxfr("frantj.cc.", soa.Serial, "ns.frantj.cc.", "hostmaster.frantj.cc.")
+
+	// A secondary that is up-to-date gets just the SOA.
+	if rrs, err = transferIn(t, addr, ixfr.Copy(), true); err != nil {
+		t.Fatal(err)
+	} else if len(rrs) != 1 || rrs[0].(*dns.SOA).Serial != soa.Serial {
+		t.Fatalf("expected just the SOA, got %v", rrs)
+	}
+
+	if err := p.ApplyChanges(context.TODO(), &plan.Changes{
+		UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5")},
+		UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.7")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if rrs, err = transferIn(t, addr, ixfr.Copy(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	// SOA(new), SOA(old), deleted A, SOA(new), added A, SOA(new).
+	if len(rrs) != 6 {
+		t.Fatalf("expected an incremental transfer of 6 records, got %d: %v", len(rrs), rrs)
+	}
+
+	current := rrs[0].(*dns.SOA).Serial
+	if current <= soa.Serial {
+		t.Fatalf("expected serial to increase from %d, got %d", soa.Serial, current)
+	} else if rrs[1].(*dns.SOA).Serial != soa.Serial || rrs[2].(*dns.A).A.String() != "10.0.0.5" {
+		t.Fatalf("expected the deletion of 10.0.0.5 from serial %d, got %v", soa.Serial, rrs[1:3])
+	} else if rrs[3].(*dns.SOA).Serial != current || rrs[4].(*dns.A).A.String() != "10.0.0.7" {
+		t.Fatalf("expected the addition of 10.0.0.7 at serial %d, got %v", current, rrs[3:5])
+	}
+
+	// A secondary that is further behind than the journal gets the whole zone.
+	ixfr.SetIxfr("frantj.cc.", soa.Serial-1, "ns.frantj.cc.", "hostmaster.frantj.cc.")
+	if rrs, err = transferIn(t, addr, ixfr.Copy(), true); err != nil {
+		t.Fatal(err)
+	} else if len(rrs) != 4 || rrs[2].(*dns.A).A.String() != "10.0.0.7" {
+		t.Fatalf("expected a full transfer, got %v", rrs)
+	}
+
+	// Peers that are not allowed are refused.
+	if _, err := transferIn(t, startTransferServer(t, "192.0.2.1"), axfr.Copy(), true); err == nil {
+		t.Fatal("expected transfer to a peer that is not allowed to be refused")
+	}
+}
+
+func TestTransferSigned(t *testing.T) {
+	k, err := dnssec.GenerateKey("frantj.cc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := newExternalDNS(t,
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		endpoint.NewEndpoint("app.apps.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+	)
+	e.Zones = []string{"frantj.cc."}
+	e.Keys = []*dnssec.Key{k}
+	e.NSEC3 = true
+
+	serial := e.Source.(*externaldns.Provider).Serial()
+
+	// Signed zones are transferred in full, even to secondaries
+	// that could otherwise be sent what changed incrementally.
+	for _, from := range []uint32{0, serial - 1} {
+		ch, err := e.Transfer("frantj.cc.", from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rrs := []dns.RR{}
+		for records := range ch {
+			rrs = append(rrs, records...)
+		}
+
+		if _, ok := rrs[0].(*dns.SOA); !ok {
+			t.Fatalf("expected transfer to start with the SOA, got %v", rrs[0])
+		} else if _, ok := rrs[len(rrs)-1].(*dns.SOA); !ok {
+			t.Fatalf("expected transfer to end with the SOA, got %v", rrs[len(rrs)-1])
+		}
+
+		count := map[uint16]int{}
+		for _, rr := range rrs[:len(rrs)-1] {
+			count[rr.Header().Rrtype]++
+		}
+
+		// frantj.cc., www.frantj.cc., apps.frantj.cc. and app.apps.frantj.cc.
+		if count[dns.TypeNSEC3] != 4 {
+			t.Fatalf("expected an NSEC3 record for each name, got %d", count[dns.TypeNSEC3])
+		}
+
+		// SOA, NS, DNSKEY, CDS, CDNSKEY, NSEC3PARAM, 2 A and 4 NSEC3.
+		if count[dns.TypeRRSIG] != 12 {
+			t.Fatalf("expected an RRSIG for each RRset, got %d", count[dns.TypeRRSIG])
+		}
+
+		verify(t, k, rrs[:len(rrs)-1])
+	}
+}
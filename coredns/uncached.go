@@ -0,0 +1,57 @@
+package coredns
+
+import (
+	"reflect"
+
+	"github.com/coredns/coredns/plugin/cache"
+	"github.com/miekg/dns"
+)
+
+// uncached returns w without the cache plugin's ResponseWriter in the
+// chain of ResponseWriters that it wraps, if there is one, so that answers
+// from the Source, which are always current, are neither cached, and so
+// served after they change, nor have their TTLs capped by it. Those that
+// wrap the cache's, e.g. the header plugin's, are copied to wrap what it
+// wraps instead, so they still apply.
+func uncached(w dns.ResponseWriter) dns.ResponseWriter {
+	if u, ok := unwrapCache(w); ok {
+		return u
+	}
+
+	return w
+}
+
+var responseWriterType = reflect.TypeFor[dns.ResponseWriter]()
+
+func unwrapCache(w dns.ResponseWriter) (dns.ResponseWriter, bool) {
+	v := reflect.ValueOf(w)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	if cw, ok := w.(*cache.ResponseWriter); ok {
+		// A prefetch writes nothing back to the client,
+		// so what it writes must go to the cache.
+		if prefetch := v.Elem().FieldByName("prefetch"); prefetch.IsValid() && prefetch.Bool() {
+			return nil, false
+		}
+
+		return cw.ResponseWriter, true
+	}
+
+	field := v.Elem().FieldByName("ResponseWriter")
+	if !field.IsValid() || field.Type() != responseWriterType || field.IsNil() {
+		return nil, false
+	}
+
+	inner, ok := unwrapCache(field.Interface().(dns.ResponseWriter))
+	if !ok {
+		return nil, false
+	}
+
+	cp := reflect.New(v.Elem().Type())
+	cp.Elem().Set(v.Elem())
+	cp.Elem().FieldByName("ResponseWriter").Set(reflect.ValueOf(&inner).Elem())
+
+	return cp.Interface().(dns.ResponseWriter), true
+}
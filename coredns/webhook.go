@@ -0,0 +1,537 @@
+package coredns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/health"
+	"github.com/frantjc/external-dns-dnsserver-webhook/hosts"
+	"github.com/frantjc/external-dns-dnsserver-webhook/internal/logutil"
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
+	"github.com/frantjc/external-dns-dnsserver-webhook/zonefile"
+	xurl "github.com/frantjc/x/net/url"
+	"github.com/miekg/dns"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/provider/webhook/api"
+)
+
+const (
+	// StoreHosts keeps records in memory and, with a StateDir,
+	// persists them to a JSON file alongside a hosts file.
+	StoreHosts = "hosts"
+	// StoreBolt keeps records in a bbolt database in the StateDir.
+	StoreBolt = "bolt"
+	// StoreConfigMap keeps records in a Kubernetes ConfigMap
+	// in the current namespace that replicas share.
+	StoreConfigMap = "configmap"
+	// StoreRaft replicates records across a Raft cluster.
+	StoreRaft = "raft"
+)
+
+// Webhook runs an externaldns.Provider for the plugin to answer from. It
+// serves the external-dns webhook API for the Provider, keeps the records
+// that it answers from up-to-date and health checks them.
+type Webhook struct {
+	// Addr is the address to serve the external-dns webhook API on.
+	Addr string
+	// InitialHosts is the path or URL of a hosts file to
+	// serve records from alongside those from external-dns.
+	InitialHosts string
+	// InitialZone is the path or URL of a zone file to serve
+	// records from alongside those from external-dns.
+	InitialZone string
+	// InitialZoneOrigin is the origin of names in InitialZone
+	// that are relative, unless it has its own $ORIGIN.
+	InitialZoneOrigin string
+	// InitialHostsManaged lets external-dns update and delete the records
+	// from InitialHosts and InitialZone rather than treating them as
+	// read-only. They are then put in the store only if it is empty.
+	InitialHostsManaged bool
+	// HideRegistryTXT keeps the TXT records of the
+	// external-dns TXT registry out of answers.
+	HideRegistryTXT bool
+	// DefaultTTL is the TTL of records that do not specify one.
+	DefaultTTL endpoint.TTL
+	// DomainFilter limits the records that external-dns can make.
+	DomainFilter *endpoint.DomainFilter
+	// StateDir is where records are persisted across restarts.
+	StateDir string
+	// Store is which store to keep records in, StoreHosts if empty.
+	Store string
+	// ConfigMap is the name of the ConfigMap to keep records in.
+	ConfigMap string
+	// Raft configures this node of the Raft cluster to keep records in.
+	Raft store.RaftConfig
+	// HealthCheckInterval is the time between health checks of records
+	// with a health check, health.DefaultInterval if unset.
+	HealthCheckInterval time.Duration
+	// HealthCheckTimeout is the timeout of health checks of records
+	// with a health check, health.DefaultTimeout if unset.
+	HealthCheckTimeout time.Duration
+	// Servers are the ExternalDNS that answer from the records, whose
+	// Zones are exported on URLZone as they are transferred.
+	Servers []*ExternalDNS
+
+	mu       sync.RWMutex
+	provider *externaldns.Provider
+	source   Source
+	stop     func() error
+}
+
+var (
+	_ SerialSource = &Webhook{}
+	_ WatchSource  = &Webhook{}
+	_ ResignSource = &Webhook{}
+)
+
+// Lookup implements Source.
+func (w *Webhook) Lookup(name string) []*endpoint.Endpoint {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.source == nil {
+		return nil
+	}
+
+	return w.source.Lookup(name)
+}
+
+// Exists implements Source.
+func (w *Webhook) Exists(name string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.source == nil {
+		return false
+	}
+
+	return w.source.Exists(name)
+}
+
+// Serial implements SerialSource.
+func (w *Webhook) Serial() uint32 {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.provider.Serial()
+}
+
+// Version implements TransferSource.
+func (w *Webhook) Version() *externaldns.Version {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.provider.Version()
+}
+
+// Touch implements ResignSource.
+func (w *Webhook) Touch() {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	w.provider.Touch()
+}
+
+// Watch implements WatchSource.
+func (w *Webhook) Watch(ctx context.Context) (<-chan struct{}, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.provider == nil {
+		return nil, fmt.Errorf("webhook is not started")
+	}
+
+	return w.provider.Watch(ctx)
+}
+
+// Start opens the store and starts serving the webhook API
+// and answering from its records until Stop is called.
+func (w *Webhook) Start() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop != nil {
+		return nil
+	}
+
+	var (
+		log         = slog.Default()
+		ctx, cancel = context.WithCancel(logutil.SloggerInto(context.Background(), log))
+		eg, egctx   = errgroup.WithContext(ctx)
+		closers     = []func() error{}
+		stop        = func() error {
+			cancel()
+
+			errs := []error{}
+			if err := eg.Wait(); !errors.Is(err, context.Canceled) {
+				errs = append(errs, err)
+			}
+
+			for _, c := range slices.Backward(closers) {
+				errs = append(errs, c())
+			}
+
+			return errors.Join(errs...)
+		}
+	)
+
+	fail := func(err error) error {
+		return errors.Join(err, stop())
+	}
+
+	h, err := w.openInitialHosts(ctx)
+	if err != nil {
+		return fail(err)
+	}
+
+	z, err := w.openInitialZone(ctx)
+	if err != nil {
+		return fail(err)
+	}
+
+	// The store may add its own records to h.
+	initialEndpoints := append(externaldns.EndpointsFromHosts(h), externaldns.EndpointsFromZone(z)...)
+
+	// Managed initial records are only in the store, so that
+	// the hosts file does not keep those that are deleted.
+	if w.InitialHostsManaged {
+		h = &hosts.Hosts{}
+	}
+
+	s, closer, err := w.openStore(egctx, eg, h)
+	if err != nil {
+		return fail(err)
+	}
+
+	if closer != nil {
+		closers = append(closers, closer)
+	}
+
+	p := &externaldns.Provider{
+		Store:           s,
+		HideRegistryTXT: w.HideRegistryTXT,
+		DefaultTTL:      w.DefaultTTL,
+		DomainFilter:    w.DomainFilter,
+	}
+
+	if w.InitialHostsManaged {
+		current, err := s.List(ctx)
+		if err != nil {
+			return fail(err)
+		}
+
+		// The initial records only seed a store that is empty, e.g.
+		// new, so that those that external-dns has since updated
+		// or deleted are not put back each time that it starts.
+		if len(current) == 0 {
+			if err := s.Apply(ctx, &store.Changes{Put: initialEndpoints}); err != nil {
+				return fail(err)
+			}
+		}
+	} else {
+		p.ReadOnlyEndpoints = initialEndpoints
+	}
+
+	eg.Go(func() error {
+		return p.Run(egctx)
+	})
+
+	checker := &health.Checker{
+		Source:   p,
+		Interval: w.HealthCheckInterval,
+		Timeout:  w.HealthCheckTimeout,
+	}
+
+	eg.Go(func() error {
+		return checker.Run(egctx)
+	})
+
+	if w.Addr != "" {
+		l, err := net.Listen("tcp", w.Addr)
+		if err != nil {
+			return fail(err)
+		}
+
+		var (
+			ws  = &api.WebhookServer{Provider: p}
+			mux = http.NewServeMux()
+			srv = &http.Server{
+				ReadHeaderTimeout: time.Second * 5,
+				ReadTimeout:       time.Second * 5,
+				BaseContext: func(_ net.Listener) context.Context {
+					return ctx
+				},
+				Handler: mux,
+			}
+		)
+
+		mux.HandleFunc("/", ws.NegotiateHandler)
+		mux.HandleFunc(api.UrlRecords, ws.RecordsHandler)
+		mux.HandleFunc(api.UrlAdjustEndpoints, ws.AdjustEndpointsHandler)
+		servers := slices.Clone(w.Servers)
+		mux.HandleFunc("GET "+URLZone, func(rw http.ResponseWriter, r *http.Request) {
+			serveZone(rw, r, p, servers)
+		})
+
+		eg.Go(func() error {
+			log.Info("webhook listening on " + l.Addr().String())
+
+			if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+
+			return nil
+		})
+
+		eg.Go(func() error {
+			<-egctx.Done()
+			sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second*5)
+			defer cancel()
+			return srv.Shutdown(sctx)
+		})
+	}
+
+	go func() {
+		if err := eg.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+			log.Error("webhook stopped", "err", err)
+		}
+	}()
+
+	w.provider = p
+	w.source = checker
+	w.stop = stop
+
+	return nil
+}
+
+// Stop stops what Start started and closes the store.
+func (w *Webhook) Stop() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop == nil {
+		return nil
+	}
+
+	err := w.stop()
+	w.provider = nil
+	w.source = nil
+	w.stop = nil
+
+	return err
+}
+
+func (w *Webhook) openInitialHosts(ctx context.Context) (*hosts.Hosts, error) {
+	if w.InitialHosts == "" {
+		return &hosts.Hosts{}, nil
+	}
+
+	r, err := xurl.OpenContext(ctx, w.InitialHosts)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	h, err := hosts.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("decode initial hosts %s: %w", w.InitialHosts, err)
+	}
+
+	logutil.SloggerFrom(ctx).Info("parsed initial hosts "+w.InitialHosts, "len", len(h.Hosts))
+
+	return h, nil
+}
+
+func (w *Webhook) openInitialZone(ctx context.Context) (*zonefile.Zone, error) {
+	if w.InitialZone == "" {
+		return nil, nil
+	}
+
+	r, err := xurl.OpenContext(ctx, w.InitialZone)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	z, err := zonefile.Decode(r, w.InitialZoneOrigin)
+	if err != nil {
+		return nil, fmt.Errorf("decode initial zone %s: %w", w.InitialZone, err)
+	}
+
+	logutil.SloggerFrom(ctx).Info("parsed initial zone "+w.InitialZone, "len", len(z.Records))
+
+	return z, nil
+}
+
+// URLZone is the path that the webhook API exports the current records
+// on as a zone file, for the origin in the query, if any. A zone that
+// one of Webhook.Servers answers for is exported as it is transferred,
+// starting with the SOA, NS and DNSSEC records at its apex. Otherwise,
+// the export is only of the records, e.g. to import elsewhere.
+const URLZone = "/zone"
+
+// serveZone writes the records of p in the zone of the "origin"
+// query parameter as a zone file, as transferred by the first
+// of servers that answers for it, if any.
+func serveZone(w http.ResponseWriter, r *http.Request, p *externaldns.Provider, servers []*ExternalDNS) {
+	origin := r.URL.Query().Get("origin")
+	if _, ok := dns.IsDomainName(origin); !ok && origin != "" {
+		http.Error(w, "invalid origin "+origin, http.StatusBadRequest)
+		return
+	}
+
+	ttl := p.DefaultTTL
+	if !ttl.IsConfigured() {
+		ttl = DefaultTTL
+	}
+
+	var z *zonefile.Zone
+
+	if zone := strings.ToLower(dns.Fqdn(origin)); origin != "" {
+		for _, e := range servers {
+			if slices.Contains(e.Zones, zone) {
+				z = &zonefile.Zone{Origin: zone, TTL: uint32(ttl), Records: e.axfr(zone, p.Version())}
+				break
+			}
+		}
+	}
+
+	if z == nil {
+		endpoints, err := p.Records(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		z = externaldns.ZoneFromEndpoints(origin, ttl, endpoints)
+	}
+
+	b := new(bytes.Buffer)
+	if err := z.Encode(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/dns")
+	_, _ = w.Write(b.Bytes())
+}
+
+// openStore opens the store to keep records in, starting anything that
+// it needs to run in eg, and returns a function to close it, if any.
+func (w *Webhook) openStore(ctx context.Context, eg *errgroup.Group, h *hosts.Hosts) (store.Store, func() error, error) {
+	log := logutil.SloggerFrom(ctx)
+
+	if w.StateDir != "" {
+		if err := os.MkdirAll(w.StateDir, 0o755); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch w.Store {
+	case "", StoreHosts:
+		hf := &store.HostsFile{
+			Hosts: h,
+		}
+
+		if w.StateDir != "" {
+			hf.File = filepath.Join(w.StateDir, "hosts")
+			hf.StateFile = filepath.Join(w.StateDir, "endpoints.json")
+
+			if err := hf.Load(); err != nil {
+				return nil, nil, err
+			}
+
+			log.Info("loaded state from " + hf.StateFile)
+
+			// Records are served from memory, but the hosts
+			// file is kept for anything else that reads it.
+			if err := store.WriteFileAtomic(hf.File, h.Encode); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		return hf, nil, nil
+	case StoreBolt:
+		if w.StateDir == "" {
+			return nil, nil, fmt.Errorf("store %s requires a state directory", w.Store)
+		}
+
+		b, err := store.OpenBolt(filepath.Join(w.StateDir, "endpoints.db"))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		log.Info("opened store " + filepath.Join(w.StateDir, "endpoints.db"))
+
+		return b, b.Close, nil
+	case StoreConfigMap:
+		clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			clientcmd.NewDefaultClientConfigLoadingRules(),
+			&clientcmd.ConfigOverrides{},
+		)
+
+		restConfig, err := clientConfig.ClientConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		namespace, _, err := clientConfig.Namespace()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		client, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cm := &store.ConfigMap{
+			Client:    client,
+			Namespace: namespace,
+			Name:      w.ConfigMap,
+		}
+
+		if err := cm.Load(ctx); err != nil {
+			return nil, nil, err
+		}
+
+		eg.Go(func() error {
+			return cm.Run(ctx)
+		})
+
+		log.Info("watching store configmap " + namespace + "/" + w.ConfigMap)
+
+		return cm, nil, nil
+	case StoreRaft:
+		cfg := w.Raft
+		if w.StateDir != "" && cfg.Dir == "" {
+			cfg.Dir = filepath.Join(w.StateDir, "raft")
+		}
+
+		r, err := store.OpenRaft(ctx, &cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		log.Info("raft node " + cfg.ID + " listening on " + cfg.Addr)
+
+		return r, r.Close, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown store %q", w.Store)
+}
@@ -0,0 +1,196 @@
+package coredns_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/coredns"
+	"sigs.k8s.io/external-dns/provider/webhook/api"
+)
+
+func TestWebhook(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Free the port for the webhook to listen on.
+	addr := l.Addr().String()
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	initialHosts := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(initialHosts, []byte("10.0.0.1 frantj.cc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	initialZone := filepath.Join(t.TempDir(), "db.frantj.cc")
+	if err := os.WriteFile(initialZone, []byte("txt IN TXT \"hello\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &coredns.Webhook{
+		Addr:              addr,
+		InitialHosts:      initialHosts,
+		InitialZone:       initialZone,
+		InitialZoneOrigin: "frantj.cc",
+		StateDir:          t.TempDir(),
+	}
+	w.Servers = []*coredns.ExternalDNS{{Source: w, Zones: []string{"frantj.cc."}}}
+
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop() //nolint:errcheck
+
+	if eps := w.Lookup("frantj.cc"); len(eps) != 1 {
+		t.Fatalf("expected initial hosts to be served, got %v", eps)
+	} else if eps := w.Lookup("txt.frantj.cc"); len(eps) != 1 {
+		t.Fatalf("expected initial zone to be served, got %v", eps)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+api.UrlRecords, strings.NewReader(
+		`{"Create":[{"dnsName":"www.frantj.cc","recordType":"A","targets":["10.0.0.5"]}]}`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", api.MediaTypeFormatAndVersion)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+
+	if eps := w.Lookup("www.frantj.cc"); len(eps) != 1 {
+		t.Fatalf("expected www.frantj.cc to be served, got %v", eps)
+	}
+
+	res, err = http.Get("http://" + addr + coredns.URLZone + "?origin=frantj.cc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The zone is exported as it is transferred, along with its apex.
+	for _, line := range []string{"$ORIGIN frantj.cc.\n", "$TTL 3600\n", "@\t\tIN\tSOA\tns.frantj.cc. ", "@\t\tIN\tNS\tns.frantj.cc.\n", "@\t\tIN\tA\t10.0.0.1\n", "www\t\tIN\tA\t10.0.0.5\n", "txt\t\tIN\tTXT\t\"hello\"\n"} {
+		if !strings.Contains(string(b), line) {
+			t.Fatalf("expected %q in exported zone:\n%s", line, b)
+		}
+	}
+
+	res, err = http.Get("http://" + addr + coredns.URLZone + "?origin=www.frantj.cc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if b, err = io.ReadAll(res.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other origins only get the records.
+	if !strings.Contains(string(b), "@\t\tIN\tA\t10.0.0.5\n") || strings.Contains(string(b), "SOA") {
+		t.Fatalf("expected only the records in exported zone:\n%s", b)
+	}
+
+	// As on a Corefile reload, stop and start again on the same address.
+	if err := w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if eps := w.Lookup("www.frantj.cc"); len(eps) != 0 {
+		t.Fatalf("expected nothing to be served once stopped, got %v", eps)
+	}
+
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if eps := w.Lookup("www.frantj.cc"); len(eps) != 1 {
+		t.Fatalf("expected www.frantj.cc to be reloaded from the state directory, got %v", eps)
+	}
+}
+
+func TestWebhookInitialHostsManaged(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Free the port for the webhook to listen on.
+	addr := l.Addr().String()
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	initialHosts := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(initialHosts, []byte("10.0.0.1 frantj.cc\n10.0.0.2 www.frantj.cc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &coredns.Webhook{
+		Addr:                addr,
+		InitialHosts:        initialHosts,
+		InitialHostsManaged: true,
+		StateDir:            t.TempDir(),
+	}
+
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop() //nolint:errcheck
+
+	if eps := w.Lookup("www.frantj.cc"); len(eps) != 1 {
+		t.Fatalf("expected initial hosts to seed the store, got %v", eps)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+api.UrlRecords, strings.NewReader(
+		`{"Delete":[{"dnsName":"www.frantj.cc","recordType":"A","targets":["10.0.0.2"]}]}`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", api.MediaTypeFormatAndVersion)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+
+	if err := w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The store is no longer empty, so it is not seeded again.
+	if eps := w.Lookup("www.frantj.cc"); len(eps) != 0 {
+		t.Fatalf("expected www.frantj.cc to stay deleted, got %v", eps)
+	} else if eps := w.Lookup("frantj.cc"); len(eps) != 1 {
+		t.Fatalf("expected frantj.cc to be reloaded from the state directory, got %v", eps)
+	}
+}
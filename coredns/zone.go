@@ -0,0 +1,117 @@
+package coredns
+
+import (
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/miekg/dns"
+)
+
+const (
+	// zoneTTL is the TTL of the SOA and NS records of Zones.
+	zoneTTL = 3600
+	// zoneMinTTL is the TTL that negative answers are cached for.
+	zoneMinTTL = 60
+	// zoneRefresh, zoneRetry and zoneExpire are the
+	// timers of secondaries in the SOA records of Zones.
+	zoneRefresh = 7200
+	zoneRetry   = 1800
+	zoneExpire  = 1209600
+)
+
+// SerialSource is a Source that numbers each version of its Endpoints
+// with a serial that increases whenever they change.
+type SerialSource interface {
+	Source
+	Serial() uint32
+}
+
+// zone returns the zone in e.Zones that name is in, if any.
+func (e *ExternalDNS) zone(name string) string {
+	return plugin.Zones(e.Zones).Matches(strings.ToLower(dns.Fqdn(name)))
+}
+
+// serial returns the serial of the SOA records of e.Zones.
+func (e *ExternalDNS) serial() uint32 {
+	if src, ok := e.Source.(SerialSource); ok {
+		return src.Serial()
+	}
+
+	return 1
+}
+
+// nameservers returns the names of the nameservers of zone,
+// which default to "ns.<zone>".
+func (e *ExternalDNS) nameservers(zone string) []string {
+	if len(e.Nameservers) > 0 {
+		return e.Nameservers
+	}
+
+	return []string{"ns." + zone}
+}
+
+// soa returns the SOA record of zone.
+func (e *ExternalDNS) soa(zone string) *dns.SOA {
+	return e.soaWithSerial(zone, e.serial())
+}
+
+// negativeSOA returns the SOA record of zone for the authority section
+// of negative answers, whose TTL is that which they are cached for, as
+// per RFC 2308 section 3.
+func (e *ExternalDNS) negativeSOA(zone string) *dns.SOA {
+	soa := e.soa(zone)
+	soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+
+	return soa
+}
+
+// soaWithSerial returns the SOA record of zone as of serial.
+func (e *ExternalDNS) soaWithSerial(zone string, serial uint32) *dns.SOA {
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    zoneTTL,
+		},
+		Ns:      e.nameservers(zone)[0],
+		Mbox:    "hostmaster." + zone,
+		Serial:  serial,
+		Refresh: zoneRefresh,
+		Retry:   zoneRetry,
+		Expire:  zoneExpire,
+		Minttl:  zoneMinTTL,
+	}
+}
+
+// apex returns the records of type qtype at the apex of zone
+// that are synthesized rather than answered from the Source.
+func (e *ExternalDNS) apex(zone string, qtype uint16) []dns.RR {
+	switch qtype {
+	case dns.TypeSOA:
+		return []dns.RR{e.soa(zone)}
+	case dns.TypeNS:
+		return e.ns(zone)
+	}
+
+	return e.dnskeys(zone, qtype)
+}
+
+// ns returns the NS records of zone.
+func (e *ExternalDNS) ns(zone string) []dns.RR {
+	rrs := []dns.RR{}
+
+	for _, ns := range e.nameservers(zone) {
+		rrs = append(rrs, &dns.NS{
+			Hdr: dns.RR_Header{
+				Name:   zone,
+				Rrtype: dns.TypeNS,
+				Class:  dns.ClassINET,
+				Ttl:    zoneTTL,
+			},
+			Ns: ns,
+		})
+	}
+
+	return rrs
+}
@@ -0,0 +1,225 @@
+package dnssec
+
+import (
+	"encoding/base32"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// NSEC returns the NSEC record at name that denies every type not in
+// types. Its next name is the immediate successor of name, so it denies
+// no other name. These are the "black lies" of RFC 4470 and RFC 9824: a
+// name that does not exist, for which types is nil, gets an NSEC record
+// with no types but RRSIG, NSEC and NXNAME, i.e. a NODATA response that
+// validators can still tell apart from an empty non-terminal, rather than
+// an NXDOMAIN response.
+func NSEC(name string, ttl uint32, types []uint16) *dns.NSEC {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	if types == nil {
+		types = []uint16{dns.TypeNXNAME}
+	}
+
+	return &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		NextDomain: `\000.` + name,
+		TypeBitMap: bitmap(types, dns.TypeRRSIG, dns.TypeNSEC),
+	}
+}
+
+// NSEC3PARAM returns the NSEC3PARAM record of zone. NSEC3 records
+// are hashed with SHA-1 with no extra iterations and no salt,
+// as RFC 9276 recommends.
+func NSEC3PARAM(zone string, ttl uint32) *dns.NSEC3PARAM {
+	return &dns.NSEC3PARAM{
+		Hdr: dns.RR_Header{
+			Name:   strings.ToLower(dns.Fqdn(zone)),
+			Rrtype: dns.TypeNSEC3PARAM,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Hash: dns.SHA1,
+	}
+}
+
+// NSEC3 returns the NSEC3 record of zone that matches name, denying every
+// type not in types. Its next hashed owner name is the immediate successor
+// of that of name, so it denies no other name, see RFC 7129 appendix B.
+func NSEC3(zone, name string, ttl uint32, types []uint16) *dns.NSEC3 {
+	h := hashName(name)
+
+	if len(types) > 0 {
+		types = bitmap(types, dns.TypeRRSIG)
+	}
+
+	return newNSEC3(zone, h, addHash(h, 1), ttl, types)
+}
+
+// NSEC3Cover returns an NSEC3 record of zone that covers
+// name, proving that it does not exist, and no other name.
+func NSEC3Cover(zone, name string, ttl uint32) *dns.NSEC3 {
+	h := hashName(name)
+
+	return newNSEC3(zone, addHash(h, -1), addHash(h, 1), ttl, nil)
+}
+
+// SignZone returns rrs, the records of zone, with a chain of NSEC records,
+// or NSEC3 records if nsec3, that denies the existence of any other records
+// and the RRSIG records of each RRset, e.g. for a transfer to a secondary.
+// rrs must include the records at the apex of zone, i.e. its SOA, NS and
+// DNSKEY records, as well as its NSEC3PARAM record if nsec3. ttl is the
+// TTL of the denial records.
+func (s *Signer) SignZone(zone string, rrs []dns.RR, nsec3 bool, ttl uint32, now time.Time) ([]dns.RR, error) {
+	var (
+		types = map[string][]uint16{}
+		names = []string{}
+	)
+
+	zone = strings.ToLower(dns.Fqdn(zone))
+
+	for _, rr := range rrs {
+		name := strings.ToLower(rr.Header().Name)
+		if _, ok := types[name]; !ok {
+			names = append(names, name)
+		}
+
+		types[name] = append(types[name], rr.Header().Rrtype)
+	}
+
+	denial := []dns.RR{}
+
+	if nsec3 {
+		// Empty non-terminals exist too, so they get NSEC3 records.
+		for _, name := range names {
+			for i, end := dns.NextLabel(name, 0); !end && dns.IsSubDomain(zone, name[i:]); i, end = dns.NextLabel(name, i) {
+				if _, ok := types[name[i:]]; !ok {
+					types[name[i:]] = nil
+					names = append(names, name[i:])
+				}
+			}
+		}
+
+		var (
+			hashes = map[string]string{}
+			sorted = []string{}
+		)
+
+		for _, name := range names {
+			h := hashName(name)
+			hashes[h] = name
+			sorted = append(sorted, h)
+		}
+		slices.Sort(sorted)
+
+		for i, h := range sorted {
+			t := types[hashes[h]]
+			if len(t) > 0 {
+				t = bitmap(t, dns.TypeRRSIG)
+			}
+
+			denial = append(denial, newNSEC3(zone, h, sorted[(i+1)%len(sorted)], ttl, t))
+		}
+	} else {
+		slices.SortFunc(names, canonicalCompare)
+
+		for i, name := range names {
+			denial = append(denial, &dns.NSEC{
+				Hdr: dns.RR_Header{
+					Name:   name,
+					Rrtype: dns.TypeNSEC,
+					Class:  dns.ClassINET,
+					Ttl:    ttl,
+				},
+				NextDomain: names[(i+1)%len(names)],
+				TypeBitMap: bitmap(types[name], dns.TypeRRSIG, dns.TypeNSEC),
+			})
+		}
+	}
+
+	signed := append(slices.Clone(rrs), denial...)
+
+	sigs, err := s.Sign(signed, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(signed, sigs...), nil
+}
+
+func newNSEC3(zone, owner, next string, ttl uint32, types []uint16) *dns.NSEC3 {
+	return &dns.NSEC3{
+		Hdr: dns.RR_Header{
+			Name:   strings.ToLower(owner) + "." + strings.ToLower(dns.Fqdn(zone)),
+			Rrtype: dns.TypeNSEC3,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Hash:       dns.SHA1,
+		HashLength: 20,
+		NextDomain: next,
+		TypeBitMap: types,
+	}
+}
+
+// bitmap returns the sorted, unique types of types and more.
+func bitmap(types []uint16, more ...uint16) []uint16 {
+	types = slices.Concat(types, more)
+	slices.Sort(types)
+
+	return slices.Compact(types)
+}
+
+var base32Hex = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// hashName returns the NSEC3 hash of name, as configured by NSEC3PARAM.
+func hashName(name string) string {
+	return dns.HashName(strings.ToLower(dns.Fqdn(name)), dns.SHA1, 0, "")
+}
+
+// addHash returns the NSEC3 hash that is delta after h,
+// wrapping around, e.g. the predecessor of h if delta is -1.
+func addHash(h string, delta int) string {
+	b, err := base32Hex.DecodeString(strings.ToUpper(h))
+	if err != nil {
+		return h
+	}
+
+	for i := len(b) - 1; i >= 0; i-- {
+		v := int(b[i]) + delta
+		b[i] = byte(v)
+
+		switch {
+		case v > 0xff:
+			delta = 1
+		case v < 0:
+			delta = -1
+		default:
+			return base32Hex.EncodeToString(b)
+		}
+	}
+
+	return base32Hex.EncodeToString(b)
+}
+
+// canonicalCompare compares names in the canonical order of RFC 4034
+// section 6.1, i.e. label by label from the root, case-insensitively.
+func canonicalCompare(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+
+	return len(la) - len(lb)
+}
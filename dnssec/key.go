@@ -0,0 +1,197 @@
+// Package dnssec signs the records of zones on-the-fly and denies the
+// existence of others with NSEC or NSEC3 records.
+package dnssec
+
+import (
+	"crypto"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Key is a DNSSEC key pair that signs every record of a zone,
+// i.e. a combined signing key, which is both its KSK and ZSK.
+type Key struct {
+	DNSKEY *dns.DNSKEY
+	Signer crypto.Signer
+}
+
+// keyTTL is the TTL of the DNSKEY records of generated Keys.
+const keyTTL = 3600
+
+// GenerateKey generates an ECDSAP256SHA256 Key for zone.
+func GenerateKey(zone string) (*Key, error) {
+	k := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   strings.ToLower(dns.Fqdn(zone)),
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    keyTTL,
+		},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+
+	priv, err := k.Generate(256)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("generated key for %s cannot sign", zone)
+	}
+
+	return &Key{DNSKEY: k, Signer: signer}, nil
+}
+
+// ReadKey reads the Key whose public and private parts are in the files
+// name.key and name.private, as written by dnssec-keygen and Key.Write,
+// e.g. "Kfrantj.cc.+013+12345". name may also end in ".key" or ".private".
+func ReadKey(name string) (*Key, error) {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".key"), ".private")
+
+	pub, err := os.Open(name + ".key")
+	if err != nil {
+		return nil, err
+	}
+	defer pub.Close()
+
+	rr, err := dns.ReadRR(pub, name+".key")
+	if err != nil {
+		return nil, err
+	}
+
+	k, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("no DNSKEY in %s.key", name)
+	}
+	k.Hdr.Name = strings.ToLower(k.Hdr.Name)
+
+	priv, err := os.Open(name + ".private")
+	if err != nil {
+		return nil, err
+	}
+	defer priv.Close()
+
+	p, err := k.ReadPrivateKey(priv, name+".private")
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := p.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key in %s.private cannot sign", name)
+	}
+
+	return &Key{DNSKEY: k, Signer: signer}, nil
+}
+
+// ReadKeys reads the Keys of zone in dir, i.e. those whose files are named
+// for zone as dnssec-keygen names them. Keys that cannot be read are skipped
+// with a warning rather than keeping the rest from being read.
+func ReadKeys(dir, zone string) ([]*Key, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "K*.private"))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		log  = slog.Default()
+		keys = []*Key{}
+	)
+
+	zone = strings.ToLower(dns.Fqdn(zone))
+
+	for _, name := range names {
+		// Keys of other zones are not parsed at all.
+		if !strings.HasPrefix(strings.ToLower(filepath.Base(name)), "k"+zone+"+") {
+			continue
+		}
+
+		k, err := ReadKey(name)
+		if err != nil {
+			log.Warn("skipping unreadable DNSSEC key", "name", name, "err", err)
+			continue
+		}
+
+		if k.Zone() == zone {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys, nil
+}
+
+// ReadOrGenerateKeys reads the Keys of zone in dir, generating one
+// and writing it to dir if there are none. It reports whether it did.
+func ReadOrGenerateKeys(dir, zone string) ([]*Key, bool, error) {
+	keys, err := ReadKeys(dir, zone)
+	if err != nil || len(keys) > 0 {
+		return keys, false, err
+	}
+
+	k, err := GenerateKey(zone)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, false, err
+	}
+
+	if _, err := k.Write(dir); err != nil {
+		return nil, false, err
+	}
+
+	return []*Key{k}, true, nil
+}
+
+// Zone returns the fully-qualified name of the zone that k belongs to.
+func (k *Key) Zone() string {
+	return k.DNSKEY.Hdr.Name
+}
+
+// Name returns the name of the files that k is written
+// to without their extension, as dnssec-keygen names them.
+func (k *Key) Name() string {
+	return fmt.Sprintf("K%s+%03d+%05d", k.DNSKEY.Hdr.Name, k.DNSKEY.Algorithm, k.DNSKEY.KeyTag())
+}
+
+// Write writes k to dir as dnssec-keygen would, returning
+// the path of the files that it wrote without their extension.
+func (k *Key) Write(dir string) (string, error) {
+	name := filepath.Join(dir, k.Name())
+
+	if err := os.WriteFile(name+".key", []byte(k.DNSKEY.String()+"\n"), 0o644); err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(name+".private", []byte(k.DNSKEY.PrivateKeyString(k.Signer)), 0o600); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// DS returns the DS record of k for the parent zone, with a SHA-256 digest.
+func (k *Key) DS() *dns.DS {
+	return k.DNSKEY.ToDS(dns.SHA256)
+}
+
+// CDS returns the CDS record of k for the parent to
+// update its DS record from, see RFC 7344.
+func (k *Key) CDS() *dns.CDS {
+	return k.DS().ToCDS()
+}
+
+// CDNSKEY returns the CDNSKEY record of k for the parent
+// to update its DS record from, see RFC 7344.
+func (k *Key) CDNSKEY() *dns.CDNSKEY {
+	return k.DNSKEY.ToCDNSKEY()
+}
@@ -0,0 +1,60 @@
+package dnssec_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/dnssec"
+)
+
+func TestReadOrGenerateKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	keys, generated, err := dnssec.ReadOrGenerateKeys(dir, "Frantj.cc")
+	if err != nil {
+		t.Fatal(err)
+	} else if !generated || len(keys) != 1 {
+		t.Fatalf("expected a key to be generated, got %v", keys)
+	} else if keys[0].Zone() != "frantj.cc." {
+		t.Fatalf("expected key for frantj.cc., got %s", keys[0].Zone())
+	}
+
+	read, generated, err := dnssec.ReadOrGenerateKeys(dir, "frantj.cc")
+	if err != nil {
+		t.Fatal(err)
+	} else if generated || len(read) != 1 {
+		t.Fatalf("expected the generated key to be read back, got %v", read)
+	} else if read[0].DS().String() != keys[0].DS().String() {
+		t.Fatalf("expected DS %s, got %s", keys[0].DS(), read[0].DS())
+	}
+
+	// Keys that cannot be read are skipped, whether of
+	// the zone or of another, which is not read at all.
+	for _, name := range []string{"Kfrantj.cc.+013+00001", "Kfrantj.io.+013+00002"} {
+		for _, ext := range []string{".key", ".private"} {
+			if err := os.WriteFile(filepath.Join(dir, name+ext), []byte("garbage"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if read, err := dnssec.ReadKeys(dir, "frantj.cc"); err != nil {
+		t.Fatal(err)
+	} else if len(read) != 1 || read[0].DS().String() != keys[0].DS().String() {
+		t.Fatalf("expected only the generated key to be read, got %v", read)
+	}
+
+	if other, err := dnssec.ReadKeys(dir, "frantj.io"); err != nil {
+		t.Fatal(err)
+	} else if len(other) != 0 {
+		t.Fatalf("expected no keys for frantj.io, got %v", other)
+	}
+
+	k, err := dnssec.ReadKey(dir + "/" + keys[0].Name() + ".key")
+	if err != nil {
+		t.Fatal(err)
+	} else if k.CDS().Digest != keys[0].DS().Digest {
+		t.Fatalf("expected CDS digest %s, got %s", keys[0].DS().Digest, k.CDS().Digest)
+	}
+}
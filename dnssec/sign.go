@@ -0,0 +1,214 @@
+package dnssec
+
+import (
+	"hash/fnv"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// validity is how long signatures are valid for.
+	validity = 8 * 24 * time.Hour
+	// skew is how long before they are made signatures become valid,
+	// so that validators whose clocks are behind accept them.
+	skew = 3 * time.Hour
+	// refresh is how long before they expire signatures are made anew.
+	refresh = 2 * 24 * time.Hour
+	// ResignInterval is how often zones are to be transferred to secondary
+	// DNS servers anew, e.g. by advancing their serial, so that the
+	// signatures they have are made anew before they expire, as those
+	// that are transferred are valid for at least refresh thereafter.
+	ResignInterval = refresh / 2
+	// cacheSize caps the number of RRsets whose signatures are cached.
+	cacheSize = 10000
+)
+
+// Signer signs the records of zones with the Keys of each, caching the
+// signatures of RRsets so that each is only signed again once it changes
+// or its signatures are about to expire.
+type Signer struct {
+	keys map[string][]*Key
+
+	mu    sync.Mutex
+	cache map[uint64][]dns.RR
+}
+
+// NewSigner returns a Signer for the zones that keys belong to.
+func NewSigner(keys ...*Key) *Signer {
+	s := &Signer{
+		keys:  map[string][]*Key{},
+		cache: map[uint64][]dns.RR{},
+	}
+
+	for _, k := range keys {
+		s.keys[k.Zone()] = append(s.keys[k.Zone()], k)
+	}
+
+	return s
+}
+
+// Keys returns the Keys of zone.
+func (s *Signer) Keys(zone string) []*Key {
+	return s.keys[strings.ToLower(dns.Fqdn(zone))]
+}
+
+// Zone returns the zone that s has Keys for that name is in, if any.
+func (s *Signer) Zone(name string) string {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	for i, end := 0, false; !end; i, end = dns.NextLabel(name, i) {
+		if _, ok := s.keys[name[i:]]; ok {
+			return name[i:]
+		}
+	}
+
+	if _, ok := s.keys["."]; ok {
+		return "."
+	}
+
+	return ""
+}
+
+// Sign returns the RRSIG records of each RRset in rrs that is in a zone
+// that s has Keys for. Records of the same name and type are taken to
+// be one RRset. RRSIG records in rrs are not signed.
+func (s *Signer) Sign(rrs []dns.RR, now time.Time) ([]dns.RR, error) {
+	sigs := []dns.RR{}
+
+	for _, rrset := range rrsets(rrs) {
+		if rrset[0].Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+
+		keys := s.keys[s.Zone(rrset[0].Header().Name)]
+		if len(keys) == 0 {
+			continue
+		}
+
+		rrsigs, err := s.sign(keys, rrset, now)
+		if err != nil {
+			return nil, err
+		}
+
+		sigs = append(sigs, rrsigs...)
+	}
+
+	return sigs, nil
+}
+
+// sign returns the RRSIG records of rrset made by keys, from the cache
+// unless they are missing or about to expire.
+func (s *Signer) sign(keys []*Key, rrset []dns.RR, now time.Time) ([]dns.RR, error) {
+	key := hash(rrset)
+
+	s.mu.Lock()
+	cached, ok := s.cache[key]
+	s.mu.Unlock()
+
+	if ok && now.Add(refresh).Before(expiration(cached)) {
+		return copyRRs(cached), nil
+	}
+
+	sigs := []dns.RR{}
+
+	for _, k := range keys {
+		sig := &dns.RRSIG{
+			Hdr: dns.RR_Header{
+				Ttl: rrset[0].Header().Ttl,
+			},
+			Algorithm:  k.DNSKEY.Algorithm,
+			KeyTag:     k.DNSKEY.KeyTag(),
+			SignerName: k.Zone(),
+			Inception:  uint32(now.Add(-skew).Unix()),
+			Expiration: uint32(now.Add(validity).Unix()),
+		}
+
+		if err := sig.Sign(k.Signer, rrset); err != nil {
+			return nil, err
+		}
+
+		sigs = append(sigs, sig)
+	}
+
+	s.mu.Lock()
+	if len(s.cache) >= cacheSize {
+		clear(s.cache)
+	}
+	s.cache[key] = sigs
+	s.mu.Unlock()
+
+	return copyRRs(sigs), nil
+}
+
+// rrsets groups rrs by name and type, in the order that each first appears.
+func rrsets(rrs []dns.RR) [][]dns.RR {
+	type key struct {
+		name  string
+		rtype uint16
+	}
+
+	var (
+		sets  = [][]dns.RR{}
+		index = map[key]int{}
+	)
+
+	for _, rr := range rrs {
+		k := key{strings.ToLower(rr.Header().Name), rr.Header().Rrtype}
+
+		if i, ok := index[k]; ok {
+			sets[i] = append(sets[i], rr)
+			continue
+		}
+
+		index[k] = len(sets)
+		sets = append(sets, []dns.RR{rr})
+	}
+
+	return sets
+}
+
+// hash returns a key for the signatures of rrset,
+// regardless of the order of its records.
+func hash(rrset []dns.RR) uint64 {
+	strs := []string{}
+	for _, rr := range rrset {
+		strs = append(strs, strings.ToLower(rr.String()))
+	}
+	slices.Sort(strs)
+
+	h := fnv.New64a()
+	for _, str := range strs {
+		_, _ = h.Write([]byte(str))
+		_, _ = h.Write([]byte{0})
+	}
+
+	return h.Sum64()
+}
+
+// expiration returns the earliest expiration of sigs.
+func expiration(sigs []dns.RR) time.Time {
+	exp := time.Time{}
+
+	for _, rr := range sigs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if t := time.Unix(int64(sig.Expiration), 0); exp.IsZero() || t.Before(exp) {
+				exp = t
+			}
+		}
+	}
+
+	return exp
+}
+
+func copyRRs(rrs []dns.RR) []dns.RR {
+	cp := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		cp[i] = dns.Copy(rr)
+	}
+
+	return cp
+}
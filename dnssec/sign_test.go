@@ -0,0 +1,152 @@
+package dnssec_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/dnssec"
+	"github.com/miekg/dns"
+)
+
+func TestSign(t *testing.T) {
+	k, err := dnssec.GenerateKey("frantj.cc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		s   = dnssec.NewSigner(k)
+		now = time.Now()
+		rrs = []dns.RR{
+			mustRR(t, "www.frantj.cc. 60 IN A 10.0.0.1"),
+			mustRR(t, "www.frantj.cc. 60 IN A 10.0.0.2"),
+			mustRR(t, "txt.frantj.cc. 300 IN TXT hello"),
+			mustRR(t, "frantj.io. 300 IN A 10.0.0.3"),
+		}
+	)
+
+	sigs, err := s.Sign(rrs, now)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(sigs) != 2 {
+		t.Fatalf("expected an RRSIG for each RRset in frantj.cc., got %v", sigs)
+	}
+
+	for i, rrset := range [][]dns.RR{rrs[:2], rrs[2:3]} {
+		if err := sigs[i].(*dns.RRSIG).Verify(k.DNSKEY, rrset); err != nil {
+			t.Fatalf("verify %v: %v", rrset, err)
+		}
+	}
+
+	cached, err := s.Sign([]dns.RR{rrs[1], rrs[0]}, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	} else if cached[0].String() != sigs[0].String() {
+		t.Fatalf("expected cached signature %s, got %s", sigs[0], cached[0])
+	}
+
+	resigned, err := s.Sign(rrs[:2], now.Add(7*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	} else if resigned[0].String() == sigs[0].String() {
+		t.Fatal("expected signature about to expire to be made anew")
+	}
+}
+
+func TestNSEC3(t *testing.T) {
+	var (
+		match = dnssec.NSEC3("frantj.cc", "www.frantj.cc", 60, []uint16{dns.TypeA})
+		cover = dnssec.NSEC3Cover("frantj.cc", "nope.frantj.cc", 60)
+	)
+
+	if !match.Match("www.frantj.cc.") || match.Cover("nope.frantj.cc.") {
+		t.Fatalf("expected %s to match www.frantj.cc.", match)
+	} else if !slices.Equal(match.TypeBitMap, []uint16{dns.TypeA, dns.TypeRRSIG}) {
+		t.Fatalf("expected types A and RRSIG, got %v", match.TypeBitMap)
+	}
+
+	if !cover.Cover("nope.frantj.cc.") || cover.Match("nope.frantj.cc.") || cover.Cover("www.frantj.cc.") {
+		t.Fatalf("expected %s to cover nope.frantj.cc. alone", cover)
+	}
+
+	if nsec := dnssec.NSEC("nope.frantj.cc", 60, nil); !slices.Equal(nsec.TypeBitMap, []uint16{dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNXNAME}) {
+		t.Fatalf("expected types RRSIG, NSEC and NXNAME, got %v", nsec.TypeBitMap)
+	}
+
+	if nsec := dnssec.NSEC("empty.frantj.cc", 60, []uint16{}); !slices.Equal(nsec.TypeBitMap, []uint16{dns.TypeRRSIG, dns.TypeNSEC}) {
+		t.Fatalf("expected types RRSIG and NSEC, got %v", nsec.TypeBitMap)
+	}
+}
+
+func TestSignZone(t *testing.T) {
+	k, err := dnssec.GenerateKey("frantj.cc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		s   = dnssec.NewSigner(k)
+		rrs = []dns.RR{
+			mustRR(t, "frantj.cc. 3600 IN SOA ns.frantj.cc. hostmaster.frantj.cc. 1 7200 1800 1209600 60"),
+			mustRR(t, "frantj.cc. 3600 IN NS ns.frantj.cc."),
+			k.DNSKEY,
+			mustRR(t, "www.frantj.cc. 60 IN A 10.0.0.1"),
+			mustRR(t, "a.b.frantj.cc. 60 IN A 10.0.0.2"),
+		}
+	)
+
+	for _, tc := range []struct {
+		nsec3  bool
+		denial int
+	}{
+		// frantj.cc., www.frantj.cc. and a.b.frantj.cc.
+		{nsec3: false, denial: 3},
+		// As well as the empty non-terminal b.frantj.cc.
+		{nsec3: true, denial: 4},
+	} {
+		signed, err := s.SignZone("frantj.cc", append(rrs, dnssec.NSEC3PARAM("frantj.cc", 0)), tc.nsec3, 60, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			denial = 0
+			sigs   = 0
+		)
+
+		for _, rr := range signed {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				denial++
+				if rr.Hdr.Name == "www.frantj.cc." && rr.NextDomain != "frantj.cc." {
+					t.Fatalf("expected chain to wrap around to the apex, got %s", rr)
+				}
+			case *dns.NSEC3:
+				denial++
+			case *dns.RRSIG:
+				sigs++
+			}
+		}
+
+		if denial != tc.denial {
+			t.Fatalf("expected %d denial records, got %d:\n%v", tc.denial, denial, signed)
+		}
+
+		// SOA, NS, DNSKEY, NSEC3PARAM, 2 A and each denial record.
+		if expected := 6 + tc.denial; sigs != expected {
+			t.Fatalf("expected %d RRSIGs, got %d:\n%v", expected, sigs, signed)
+		}
+	}
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rr
+}
@@ -0,0 +1,44 @@
+package externaldns
+
+import (
+	"strings"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/hosts"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// EndpointsFromHosts groups the IPs of each hostname in h
+// into one A and one AAAA Endpoint, as applicable.
+func EndpointsFromHosts(h *hosts.Hosts) []*endpoint.Endpoint {
+	var (
+		endpoints = []*endpoint.Endpoint{}
+		index     = map[endpoint.EndpointKey]*endpoint.Endpoint{}
+	)
+
+	if h == nil {
+		return endpoints
+	}
+
+	for _, host := range h.Hosts {
+		recordType := endpoint.RecordTypeAAAA
+		if host.IP.To4() != nil {
+			recordType = endpoint.RecordTypeA
+		}
+
+		for _, hostname := range host.Hostnames {
+			hostname = strings.ToLower(hostname)
+			key := endpoint.EndpointKey{DNSName: hostname, RecordType: recordType}
+
+			if ep, ok := index[key]; ok {
+				ep.Targets = append(ep.Targets, host.IP.String())
+				continue
+			}
+
+			ep := endpoint.NewEndpoint(hostname, recordType, host.IP.String())
+			index[key] = ep
+			endpoints = append(endpoints, ep)
+		}
+	}
+
+	return endpoints
+}
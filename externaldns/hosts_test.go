@@ -0,0 +1,41 @@
+package externaldns_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/hosts"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestEndpointsFromHosts(t *testing.T) {
+	h, err := hosts.Decode(bytes.NewReader([]byte(`10.0.0.1 frantj.cc www.frantj.cc
+10.0.0.2 frantj.cc
+fd00::1 frantj.cc
+`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		actual   = externaldns.EndpointsFromHosts(h)
+		expected = []*endpoint.Endpoint{
+			endpoint.NewEndpoint("frantj.cc", endpoint.RecordTypeA, "10.0.0.1", "10.0.0.2"),
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.1"),
+			endpoint.NewEndpoint("frantj.cc", endpoint.RecordTypeAAAA, "fd00::1"),
+		}
+	)
+
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d endpoints, got %d: %v", len(expected), len(actual), actual)
+	}
+
+	for i := range expected {
+		if actual[i].DNSName != expected[i].DNSName ||
+			actual[i].RecordType != expected[i].RecordType ||
+			!actual[i].Targets.Same(expected[i].Targets) {
+			t.Fatalf("expected %v, got %v", expected[i], actual[i])
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package externaldns
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// DefaultJournalLen is the default of Provider.JournalLen.
+const DefaultJournalLen = 100
+
+// Delta is a change from one serial of the Endpoints that a Provider serves
+// to the next, as the records that were deleted and added, each an Endpoint
+// with a single target. A record whose TTL changes is both deleted and added.
+type Delta struct {
+	From, To uint32
+	Delete   []*endpoint.Endpoint
+	Add      []*endpoint.Endpoint
+}
+
+// Version is a consistent view of the Endpoints that a Provider serves,
+// e.g. to transfer them to secondary DNS servers.
+type Version struct {
+	// Serial is the serial of Endpoints, see Provider.Serial.
+	Serial uint32
+	// Endpoints are every Endpoint that is served.
+	Endpoints []*endpoint.Endpoint
+	// Journal is the most recent changes that led up
+	// to Endpoints, oldest first, ending at Serial.
+	Journal []Delta
+}
+
+// Version returns the Endpoints that Lookup and Exists answer from, along
+// with their serial and the changes that led up to them.
+func (p *Provider) Version() *Version {
+	if p == nil {
+		return nil
+	}
+
+	s := p.load()
+	v := &Version{
+		Serial:    s.serial,
+		Endpoints: p.withDefaultTTL(slices.Clone(s.list)),
+		Journal:   make([]Delta, len(s.journal)),
+	}
+
+	for i, delta := range s.journal {
+		v.Journal[i] = Delta{
+			From:   delta.From,
+			To:     delta.To,
+			Delete: p.withDefaultTTL(slices.Clone(delta.Delete)),
+			Add:    p.withDefaultTTL(slices.Clone(delta.Add)),
+		}
+	}
+
+	return v
+}
+
+// Since returns the Deltas from serial up to v.Serial, oldest first, or
+// false if v.Journal no longer goes back that far.
+func (v *Version) Since(serial uint32) ([]Delta, bool) {
+	for i, delta := range v.Journal {
+		if delta.From == serial {
+			return v.Journal[i:], true
+		}
+	}
+
+	return nil, serial == v.Serial
+}
+
+func (p *Provider) journalLen() int {
+	if p.JournalLen > 0 {
+		return p.JournalLen
+	}
+
+	return DefaultJournalLen
+}
+
+// diff returns the Delta from prev to next, or nil if they serve the same
+// records. Its From and To are left for the caller to set. It is made from
+// the records that each serves rather than from their Endpoints, so that a
+// record that is still made by another Endpoint, e.g. an alternative with
+// another set identifier that has the same target, is not deleted.
+func diff(prev, next *snapshot) *Delta {
+	var (
+		delta   = &Delta{}
+		prevRRs = records(prev.list)
+		nextRRs = records(next.list)
+		inPrev  = map[string]bool{}
+		inNext  = map[string]bool{}
+	)
+
+	for _, rr := range prevRRs {
+		inPrev[recordKey(rr, true)] = true
+	}
+
+	for _, rr := range nextRRs {
+		inNext[recordKey(rr, true)] = true
+
+		if !inPrev[recordKey(rr, true)] {
+			delta.Add = append(delta.Add, rr)
+		}
+	}
+
+	for _, rr := range prevRRs {
+		if !inNext[recordKey(rr, true)] {
+			delta.Delete = append(delta.Delete, rr)
+		}
+	}
+
+	if len(delta.Delete) == 0 && len(delta.Add) == 0 {
+		return nil
+	}
+
+	return delta
+}
+
+// records returns the records that list serves, as Endpoints with a single
+// target each. Endpoints that make the same record, e.g. alternatives for
+// the same name that share a target, make it once, with the TTL of the
+// first of them, as in ZoneFromEndpoints.
+func records(list []*endpoint.Endpoint) []*endpoint.Endpoint {
+	var (
+		rrs  = []*endpoint.Endpoint{}
+		seen = map[string]bool{}
+	)
+
+	for _, ep := range list {
+		for _, target := range ep.Targets {
+			rr := endpoint.NewEndpointWithTTL(strings.ToLower(strings.TrimSuffix(ep.DNSName, ".")), ep.RecordType, ep.RecordTTL, target)
+
+			if k := recordKey(rr, false); k != "" && !seen[k] {
+				seen[k] = true
+				rrs = append(rrs, rr)
+			}
+		}
+	}
+
+	return rrs
+}
+
+// recordKey identifies the record that ep, which has a single target,
+// makes, including its TTL if ttl, or returns "" if it makes none.
+func recordKey(ep *endpoint.Endpoint, ttl bool) string {
+	rrs := ZoneFromEndpoints(".", 0, []*endpoint.Endpoint{ep}).Records
+	if len(rrs) == 0 {
+		return ""
+	}
+
+	rrs[0].Header().Ttl = 0
+	if ttl {
+		return fmt.Sprint(rrs[0].String(), " ", ep.RecordTTL.IsConfigured(), " ", ep.RecordTTL)
+	}
+
+	return rrs[0].String()
+}
@@ -0,0 +1,128 @@
+package externaldns_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestProviderVersion(t *testing.T) {
+	p := newProvider(t)
+	p.JournalLen = 2
+
+	first := p.Version()
+
+	for _, target := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		changes := &plan.Changes{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, target)}}
+		if err := p.ApplyChanges(context.TODO(), changes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v := p.Version()
+	if v.Serial <= first.Serial {
+		t.Fatalf("expected serial to increase from %d, got %d", first.Serial, v.Serial)
+	} else if len(v.Endpoints) != 2 {
+		t.Fatalf("expected A and PTR endpoints, got %v", v.Endpoints)
+	}
+
+	if _, ok := v.Since(first.Serial); ok {
+		t.Fatal("expected the journal to no longer go back to the first serial")
+	}
+
+	deltas, ok := v.Since(v.Journal[0].From)
+	if !ok || len(deltas) != 2 || deltas[1].To != v.Serial {
+		t.Fatalf("expected the last 2 deltas, got %v", deltas)
+	}
+
+	// Creating the record again replaces 10.0.0.2 with 10.0.0.3,
+	// and the PTR for one with the PTR for the other.
+	last := deltas[1]
+	if len(last.Delete) != 2 || len(last.Add) != 2 || last.Add[0].Targets[0] != "10.0.0.3" {
+		t.Fatalf("expected 2 deletions and 2 additions, got %v and %v", last.Delete, last.Add)
+	}
+
+	if deltas, ok := v.Since(v.Serial); !ok || len(deltas) != 0 {
+		t.Fatalf("expected no deltas since the current serial, got %v", deltas)
+	}
+
+	// Changes to nothing that is served do not change the serial.
+	if err := p.ApplyChanges(context.TODO(), &plan.Changes{Delete: []*endpoint.Endpoint{
+		endpoint.NewEndpoint("gone.frantj.cc", endpoint.RecordTypeA, "10.0.0.4"),
+	}}); err != nil {
+		t.Fatal(err)
+	} else if serial := p.Serial(); serial != v.Serial {
+		t.Fatalf("expected serial %d, got %d", v.Serial, serial)
+	}
+}
+
+func TestProviderVersionSharedTarget(t *testing.T) {
+	var (
+		ctx   = context.TODO()
+		p     = newProvider(t)
+		blue  = endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.1", "10.0.0.2").WithSetIdentifier("blue").WithProviderSpecific(externaldns.WeightProperty, "1")
+		green = endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.1").WithSetIdentifier("green").WithProviderSpecific(externaldns.WeightProperty, "1")
+	)
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{Create: []*endpoint.Endpoint{blue, green}}); err != nil {
+		t.Fatal(err)
+	}
+
+	before := p.Serial()
+
+	// blue replaces 10.0.0.1 with 10.0.0.3, but green still has
+	// 10.0.0.1, so it is not deleted, and 10.0.0.3 is added along
+	// with its PTR record.
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		UpdateOld: []*endpoint.Endpoint{blue},
+		UpdateNew: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.2", "10.0.0.3").WithSetIdentifier("blue").WithProviderSpecific(externaldns.WeightProperty, "1"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deltas, ok := p.Version().Since(before)
+	if !ok || len(deltas) != 1 {
+		t.Fatalf("expected 1 delta, got %v", deltas)
+	}
+
+	if len(deltas[0].Delete) != 0 {
+		t.Fatalf("expected no deletions, got %v", deltas[0].Delete)
+	} else if len(deltas[0].Add) != 2 || deltas[0].Add[0].Targets[0] != "10.0.0.3" {
+		t.Fatalf("expected 10.0.0.3 and its PTR record to be added, got %v", deltas[0].Add)
+	}
+}
+
+func TestProviderTouch(t *testing.T) {
+	p := newProvider(t)
+
+	if err := p.ApplyChanges(context.TODO(), &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.1")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	before := p.Version()
+
+	p.Touch()
+
+	// The serial advances through a delta that changes nothing.
+	v := p.Version()
+	if v.Serial <= before.Serial {
+		t.Fatalf("expected serial to increase from %d, got %d", before.Serial, v.Serial)
+	} else if len(v.Endpoints) != len(before.Endpoints) {
+		t.Fatalf("expected endpoints %v, got %v", before.Endpoints, v.Endpoints)
+	}
+
+	deltas, ok := v.Since(before.Serial)
+	if !ok || len(deltas) != 1 || deltas[0].To != v.Serial {
+		t.Fatalf("expected 1 delta, got %v", deltas)
+	} else if len(deltas[0].Delete) != 0 || len(deltas[0].Add) != 0 {
+		t.Fatalf("expected an empty delta, got %v", deltas[0])
+	}
+}
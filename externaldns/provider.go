@@ -3,134 +3,309 @@ package externaldns
 import (
 	"context"
 	"fmt"
-	"net"
-	"os"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/frantjc/external-dns-dnsserver-webhook/hosts"
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
 	xslices "github.com/frantjc/x/slices"
 	"sigs.k8s.io/external-dns/endpoint"
 	"sigs.k8s.io/external-dns/plan"
 	"sigs.k8s.io/external-dns/provider"
 )
 
-type HostsFileProvider struct {
+// Provider is an external-dns provider that keeps Endpoints in a Store
+// and serves them to the externaldns CoreDNS plugin through Lookup.
+type Provider struct {
 	provider.BaseProvider
 	sync.Mutex
-	File string
 
-	Hosts *hosts.Hosts
+	// Store is where Endpoints are kept, in memory if unset.
+	Store store.Store
 
-	Endpoints []*endpoint.Endpoint
+	// HideRegistryTXT keeps the TXT records of the external-dns TXT registry
+	// out of DNS answers. They are still stored and returned from Records
+	// so that external-dns can determine ownership.
+	HideRegistryTXT bool
+
+	// DefaultTTL is the TTL reported and served for Endpoints that have
+	// none configured. If unset, the DNS server's default applies.
+	DefaultTTL endpoint.TTL
+
+	// DomainFilter is advertised to external-dns and limits
+	// the names that ApplyChanges accepts. If nil, all are.
+	DomainFilter *endpoint.DomainFilter
+
+	// ReadOnlyEndpoints are returned from Records alongside those in
+	// Store so that external-dns is aware of them, but changes to them
+	// are rejected. They are expected to be served by other means, e.g.
+	// from the initial hosts of a store.HostsFile.
+	ReadOnlyEndpoints []*endpoint.Endpoint
+
+	// JournalLen is the number of changes to keep for Version to return
+	// the Deltas since older serials, DefaultJournalLen if unset.
+	JournalLen int
+
+	storeOnce sync.Once
+	snapshot  atomic.Pointer[snapshot]
+	refreshMu sync.Mutex
+	watchers  store.Watchers
 }
 
-var _ provider.Provider = &HostsFileProvider{}
+// HostsFileProvider is Provider by the name that it had when Endpoints
+// were only kept in a hosts file. That hosts file is now kept by a
+// store.HostsFile set as its Store, and a zero HostsFileProvider keeps
+// Endpoints in memory.
+type HostsFileProvider = Provider
+
+var _ provider.Provider = &Provider{}
 
-func (p *HostsFileProvider) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
-	if p == nil || p.Endpoints == nil {
+func (p *Provider) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	if p == nil {
 		return []*endpoint.Endpoint{}, nil
 	}
 
-	return p.Endpoints, nil
+	endpoints, err := p.store().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never nil, so that it is encoded as an empty list rather than null.
+	records := append([]*endpoint.Endpoint{}, p.ReadOnlyEndpoints...)
+
+	return p.withDefaultTTL(append(records, endpoints...)), nil
+}
+
+// store returns p.Store, setting it to a store.HostsFile
+// that keeps Endpoints in memory first if it is unset.
+func (p *Provider) store() store.Store {
+	p.storeOnce.Do(func() {
+		if p.Store == nil {
+			p.Store = &store.HostsFile{}
+		}
+	})
+
+	return p.Store
+}
+
+// supportedRecordTypes are the record types that are served.
+var supportedRecordTypes = []string{
+	endpoint.RecordTypeA,
+	endpoint.RecordTypeAAAA,
+	endpoint.RecordTypeCNAME,
+	endpoint.RecordTypeTXT,
+}
+
+// AdjustEndpoints normalizes endpoints into the form that Records returns
+// them in so that external-dns plans converge. Endpoints of record types
+// that are not served are dropped rather than planned over and over again.
+func (p *Provider) AdjustEndpoints(endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+	adjusted := []*endpoint.Endpoint{}
+
+	for _, ep := range endpoints {
+		if ep == nil || !slices.Contains(supportedRecordTypes, ep.RecordType) {
+			continue
+		}
+
+		ep.DNSName = strings.ToLower(strings.TrimSuffix(ep.DNSName, "."))
+
+		targets := endpoint.Targets{}
+		for _, target := range ep.Targets {
+			switch ep.RecordType {
+			case endpoint.RecordTypeA, endpoint.RecordTypeAAAA:
+				if addr, err := netip.ParseAddr(target); err == nil {
+					target = addr.String()
+				}
+			case endpoint.RecordTypeCNAME:
+				target = strings.ToLower(strings.TrimSuffix(target, "."))
+			}
+
+			if !slices.Contains(targets, target) {
+				targets = append(targets, target)
+			}
+		}
+		sort.Sort(targets)
+		ep.Targets = targets
+
+		if !ep.RecordTTL.IsConfigured() && p != nil {
+			ep.RecordTTL = p.DefaultTTL
+		}
+
+		adjustRouting(ep)
+
+		adjusted = append(adjusted, ep)
+	}
+
+	return adjusted, nil
+}
+
+func (p *Provider) GetDomainFilter() endpoint.DomainFilterInterface {
+	if p == nil || p.DomainFilter == nil {
+		return &endpoint.DomainFilter{}
+	}
+
+	return p.DomainFilter
 }
 
-func (p *HostsFileProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
+// Lookup returns the Endpoints whose DNSName is name that should be served.
+func (p *Provider) Lookup(name string) []*endpoint.Endpoint {
 	if p == nil {
-		return fmt.Errorf("nil provider")
-	} else if p.Endpoints == nil {
-		p.Endpoints = []*endpoint.Endpoint{}
+		return nil
+	}
+
+	return p.withDefaultTTL(p.load().lookup(name))
+}
+
+// Exists reports whether there are Endpoints that should
+// be served whose DNSName is name or a subdomain of it.
+func (p *Provider) Exists(name string) bool {
+	if p == nil {
+		return false
 	}
 
-	var (
-		modified        bool
-		addEndpoints    = []*endpoint.Endpoint{}
-		removeEndpoints = []*endpoint.Endpoint{}
-	)
+	return p.load().exists(name)
+}
+
+// withDefaultTTL returns endpoints with p.DefaultTTL set on copies
+// of those that have no TTL configured.
+func (p *Provider) withDefaultTTL(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	if !p.DefaultTTL.IsConfigured() {
+		return endpoints
+	}
+
+	for i, ep := range endpoints {
+		if !ep.RecordTTL.IsConfigured() {
+			endpoints[i] = ep.DeepCopy()
+			endpoints[i].RecordTTL = p.DefaultTTL
+		}
+	}
+
+	return endpoints
+}
+
+// IsRegistryTXT reports whether ep is a TXT record that the external-dns
+// TXT registry uses to mark ownership, e.g. "heritage=external-dns,...".
+func IsRegistryTXT(ep *endpoint.Endpoint) bool {
+	if ep.RecordType != endpoint.RecordTypeTXT || len(ep.Targets) == 0 {
+		return false
+	}
+
+	return xslices.Every(ep.Targets, func(target string, _ int) bool {
+		_, err := endpoint.NewLabelsFromStringPlain(target)
+		return err == nil
+	})
+}
+
+func (p *Provider) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
+	if p == nil {
+		return fmt.Errorf("nil provider")
+	} else if changes == nil || !changes.HasChanges() {
+		return nil
+	}
 
 	p.Lock()
 	defer p.Unlock()
 
-	if changes != nil {
-		if changes.HasChanges() {
-			addEndpoints = append(addEndpoints, changes.Create...)
-			addEndpoints = append(addEndpoints, changes.UpdateNew...)
-			removeEndpoints = append(removeEndpoints, changes.Delete...)
+	readOnly := map[endpoint.EndpointKey]struct{}{}
+	for _, ro := range p.ReadOnlyEndpoints {
+		readOnly[endpoint.EndpointKey{DNSName: strings.ToLower(ro.DNSName), RecordType: ro.RecordType}] = struct{}{}
+	}
+
+	for _, ep := range slices.Concat(changes.Create, changes.UpdateOld, changes.UpdateNew, changes.Delete) {
+		// The TXT registry's records for wildcards look like, e.g.,
+		// "a-*.frantj.cc" unless --txt-wildcard-replacement is used.
+		if ep.RecordType != endpoint.RecordTypeTXT && !hosts.IsHostname(ep.DNSName) {
+			return fmt.Errorf("invalid DNS name for %s record: %s", ep.RecordType, ep.DNSName)
+		}
 
-			if p.Hosts == nil {
-				p.Hosts = &hosts.Hosts{}
-			}
+		if !p.GetDomainFilter().Match(ep.DNSName) {
+			return fmt.Errorf("%s record %s is outside of the domain filter", ep.RecordType, ep.DNSName)
+		}
 
-			if p.Hosts.Hosts == nil {
-				p.Hosts.Hosts = []hosts.Host{}
-			}
+		if _, ok := readOnly[endpoint.EndpointKey{DNSName: strings.ToLower(ep.DNSName), RecordType: ep.RecordType}]; ok {
+			return fmt.Errorf("%s record %s is read-only", ep.RecordType, ep.DNSName)
+		}
+	}
 
-			for _, ep := range addEndpoints {
-				if ep.RecordType == endpoint.RecordTypeA {
-					for _, target := range ep.Targets {
-						if ip := net.ParseIP(target); ip != nil {
-							modified = p.Hosts.Add(hosts.Host{
-								IP:        ip,
-								Hostnames: []string{ep.DNSName},
-							}) || modified
-						} else {
-							return fmt.Errorf("invalid IP: %s", target)
-						}
-					}
-				}
-			}
+	for _, ep := range slices.Concat(changes.UpdateNew, changes.Create) {
+		if err := validateRouting(ep); err != nil {
+			return err
+		}
 
-			for _, ep := range removeEndpoints {
-				if ep.RecordType == endpoint.RecordTypeA {
-					for _, target := range ep.Targets {
-						if ip := net.ParseIP(target); ip != nil {
-							modified = p.Hosts.Remove(hosts.Host{
-								IP:        ip,
-								Hostnames: []string{ep.DNSName},
-							}) || modified
-						} else {
-							return fmt.Errorf("invalid IP: %s", target)
-						}
-					}
+		if store.IsAddressRecordType(ep.RecordType) {
+			for _, target := range ep.Targets {
+				if _, err := store.ParseAddress(ep.RecordType, target); err != nil {
+					return err
 				}
 			}
+		}
+	}
 
-			if modified {
-				p.Endpoints = append(p.Endpoints, changes.Create...)
+	// An update replaces the old record set as a whole, so it is
+	// handled as the deletion of UpdateOld and the creation of
+	// UpdateNew. Creating a record that already exists replaces it.
+	storeChanges := &store.Changes{
+		Delete: slices.Concat(changes.UpdateOld, changes.Delete),
+		Put:    slices.Concat(changes.UpdateNew, changes.Create),
+	}
 
-				for _, up := range changes.UpdateNew {
-					for i, ex := range p.Endpoints {
-						if ex.DNSName == up.DNSName {
-							p.Endpoints[i] = up
-						}
-					}
-				}
+	current, err := p.store().List(ctx)
+	if err != nil {
+		return err
+	}
 
-				for _, del := range changes.Delete {
-					for i, ex := range p.Endpoints {
-						if ex.DNSName == del.DNSName {
-							p.Endpoints[i] = nil
-						}
-					}
-				}
+	endpoints, _ := store.Merge(current, storeChanges)
 
-				p.Endpoints = xslices.Filter(p.Endpoints, func(ep *endpoint.Endpoint, _ int) bool {
-					return ep != nil
-				})
+	if err := validateCNAMEs(append(slices.Clone(p.ReadOnlyEndpoints), endpoints...)); err != nil {
+		return err
+	}
 
-				file, err := os.Create(fmt.Sprintf("%s.tmp", p.File))
-				if err != nil {
-					return nil
-				}
-				defer os.Remove(file.Name())
-				defer file.Close()
+	if err := p.store().Apply(ctx, storeChanges); err != nil {
+		return err
+	}
 
-				if err := p.Hosts.Encode(file); err != nil {
-					return err
-				}
+	// Serve the changes right away rather than once Run sees them.
+	return p.refresh(ctx)
+}
 
-				if err := os.Rename(file.Name(), p.File); err != nil {
-					return err
-				}
+// validateCNAMEs checks that every CNAME in endpoints has exactly one
+// valid target and that no other data exists at its name.
+func validateCNAMEs(endpoints []*endpoint.Endpoint) error {
+	// Only the Endpoints at the name of each CNAME are checked against it.
+	byName := map[string][]*endpoint.Endpoint{}
+	for _, ep := range endpoints {
+		name := strings.ToLower(ep.DNSName)
+		byName[name] = append(byName[name], ep)
+	}
+
+	for _, ep := range endpoints {
+		if ep.RecordType != endpoint.RecordTypeCNAME {
+			continue
+		}
+
+		if len(ep.Targets) != 1 {
+			return fmt.Errorf("CNAME %s must have exactly one target, got %d", ep.DNSName, len(ep.Targets))
+		}
+
+		if target := strings.TrimSuffix(ep.Targets[0], "."); !hosts.IsHostname(target) || hosts.IsWildcard(target) {
+			return fmt.Errorf("invalid CNAME target for %s: %s", ep.DNSName, ep.Targets[0])
+		} else if strings.EqualFold(target, ep.DNSName) {
+			return fmt.Errorf("CNAME %s must not point to itself", ep.DNSName)
+		}
+
+		for _, ex := range byName[strings.ToLower(ep.DNSName)] {
+			// CNAMEs with different set identifiers are alternatives
+			// for the same name rather than data that coexists with it.
+			if ex.RecordType == endpoint.RecordTypeCNAME && ex.SetIdentifier != ep.SetIdentifier {
+				continue
+			}
+
+			if ex != ep {
+				return fmt.Errorf("CNAME %s cannot coexist with %s record at the same name", ep.DNSName, ex.RecordType)
 			}
 		}
 	}
@@ -0,0 +1,784 @@
+package externaldns_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/hosts"
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func newProvider(t *testing.T) *externaldns.Provider {
+	t.Helper()
+
+	return &externaldns.Provider{
+		Store: &store.HostsFile{
+			File:  filepath.Join(t.TempDir(), "hosts"),
+			Hosts: &hosts.Hosts{},
+		},
+	}
+}
+
+func hostsFile(p *externaldns.Provider) *store.HostsFile {
+	return p.Store.(*store.HostsFile)
+}
+
+func readHostsFile(t *testing.T, p *externaldns.Provider) string {
+	t.Helper()
+
+	b, err := os.ReadFile(hostsFile(p).File)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestHostsFileProviderZero(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		p   = &externaldns.HostsFileProvider{}
+	)
+
+	records, err := p.Records(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Encoded for external-dns as [] rather than null.
+	if b, err := json.Marshal(records); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "[]" {
+		t.Fatalf("expected no records, got %s", b)
+	}
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.1")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if records, err := p.Records(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %v", records)
+	}
+
+	if eps := p.Lookup("www.frantj.cc"); len(eps) != 1 {
+		t.Fatalf("expected www.frantj.cc to be served, got %v", eps)
+	}
+
+	rctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if err := p.Run(rctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestProviderApplyChangesAAAA(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		p   = newProvider(t)
+	)
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("dual.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+			endpoint.NewEndpoint("dual.frantj.cc", endpoint.RecordTypeAAAA, "fd00::5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "10.0.0.5 dual.frantj.cc\nfd00::5 dual.frantj.cc\n"
+	if actual := readHostsFile(t, p); actual != expected {
+		t.Fatalf("expected hosts file %q, got %q", expected, actual)
+	}
+
+	records, err := p.Records(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("dual.frantj.cc", endpoint.RecordTypeAAAA, "fd00::5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "10.0.0.5 dual.frantj.cc\n"
+	if actual := readHostsFile(t, p); actual != expected {
+		t.Fatalf("expected hosts file %q, got %q", expected, actual)
+	}
+
+	records, err = p.Records(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != 1 || records[0].RecordType != endpoint.RecordTypeA {
+		t.Fatalf("expected only the A record to remain, got %v", records)
+	}
+}
+
+func TestProviderApplyChangesInvalidAddress(t *testing.T) {
+	for _, ep := range []*endpoint.Endpoint{
+		endpoint.NewEndpoint("v4.frantj.cc", endpoint.RecordTypeA, "fd00::5"),
+		endpoint.NewEndpoint("v4.frantj.cc", endpoint.RecordTypeA, "::ffff:10.0.0.5"),
+		endpoint.NewEndpoint("v6.frantj.cc", endpoint.RecordTypeAAAA, "10.0.0.5"),
+		endpoint.NewEndpoint("v6.frantj.cc", endpoint.RecordTypeAAAA, "::ffff:10.0.0.5"),
+		endpoint.NewEndpoint("v6.frantj.cc", endpoint.RecordTypeAAAA, "frantj.cc"),
+	} {
+		if err := newProvider(t).ApplyChanges(context.TODO(), &plan.Changes{
+			Create: []*endpoint.Endpoint{ep},
+		}); err == nil {
+			t.Fatalf("expected error for %s", ep)
+		}
+	}
+}
+
+func TestProviderDecodedIPv6(t *testing.T) {
+	h, err := hosts.Decode(bytes.NewReader([]byte("fd00::1 frantj.cc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newProvider(t)
+	hostsFile(p).Hosts = h
+
+	if err := p.ApplyChanges(context.TODO(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeAAAA, "fd00:0:0::1"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "fd00::1 frantj.cc www.frantj.cc\n"
+	if actual := readHostsFile(t, p); actual != expected {
+		t.Fatalf("expected hosts file %q, got %q", expected, actual)
+	}
+}
+
+func TestProviderApplyChangesCNAME(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		p   = newProvider(t)
+	)
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeCNAME, "lb.example.com."),
+			endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if eps := p.Lookup("www.frantj.cc."); len(eps) != 1 || eps[0].Targets[0] != "lb.example.com" {
+		t.Fatalf("expected CNAME to lb.example.com, got %v", eps)
+	}
+
+	for _, changes := range []*plan.Changes{
+		{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.6")}},
+		{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("WWW.frantj.cc", endpoint.RecordTypeTXT, "hello")}},
+		{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeCNAME, "lb.example.com")}},
+		{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("lb.frantj.cc", endpoint.RecordTypeCNAME, "a.example.com", "b.example.com")}},
+		{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("lb.frantj.cc", endpoint.RecordTypeCNAME, "lb.frantj.cc")}},
+	} {
+		if err := p.ApplyChanges(ctx, changes); err == nil {
+			t.Fatalf("expected error for %v", changes.Create)
+		}
+	}
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeCNAME, "lb.example.com"),
+		},
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := readHostsFile(t, p); actual != "" {
+		t.Fatalf("expected empty hosts file, got %q", actual)
+	}
+}
+
+func TestProviderApplyChangesCNAMEOverInitialHosts(t *testing.T) {
+	h, err := hosts.Decode(bytes.NewReader([]byte("10.0.0.1 frantj.cc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newProvider(t)
+	hostsFile(p).Hosts = h
+	p.ReadOnlyEndpoints = externaldns.EndpointsFromHosts(h)
+
+	if err := p.ApplyChanges(context.TODO(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("frantj.cc", endpoint.RecordTypeCNAME, "lb.example.com"),
+		},
+	}); err == nil {
+		t.Fatal("expected error for CNAME over initial hosts")
+	}
+}
+
+func TestProviderApplyChangesTXT(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		p   = newProvider(t)
+		txt = endpoint.NewEndpoint("a-www.frantj.cc", endpoint.RecordTypeTXT, `"heritage=external-dns,external-dns/owner=default"`)
+	)
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			txt,
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := p.Records(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.ContainsFunc(records, func(ep *endpoint.Endpoint) bool {
+		return ep.RecordType == endpoint.RecordTypeTXT && ep.Targets.Same(txt.Targets)
+	}) {
+		t.Fatalf("expected TXT record in %v", records)
+	}
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Delete: []*endpoint.Endpoint{txt},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if records, err = p.Records(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(records) != 1 || records[0].RecordType != endpoint.RecordTypeA {
+		t.Fatalf("expected only the A record to remain, got %v", records)
+	}
+}
+
+func TestProviderHideRegistryTXT(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		p   = newProvider(t)
+	)
+
+	p.HideRegistryTXT = true
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("a-www.frantj.cc", endpoint.RecordTypeTXT, `"heritage=external-dns,external-dns/owner=default"`),
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeTXT, "v=spf1 -all"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if records, err := p.Records(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+
+	if eps := p.Lookup("a-www.frantj.cc"); len(eps) != 0 {
+		t.Fatalf("expected registry TXT to be hidden, got %v", eps)
+	}
+
+	if eps := p.Lookup("www.frantj.cc"); len(eps) != 1 {
+		t.Fatalf("expected non-registry TXT to be served, got %v", eps)
+	}
+}
+
+func TestProviderReadOnlyEndpoints(t *testing.T) {
+	h, err := hosts.Decode(bytes.NewReader([]byte("10.0.0.1 frantj.cc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ctx = context.TODO()
+		p   = newProvider(t)
+	)
+
+	hostsFile(p).Hosts = h
+	p.ReadOnlyEndpoints = externaldns.EndpointsFromHosts(h)
+
+	if records, err := p.Records(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(records) != 1 || records[0].DNSName != "frantj.cc" {
+		t.Fatalf("expected initial hosts in records, got %v", records)
+	}
+
+	for _, changes := range []*plan.Changes{
+		{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("frantj.cc", endpoint.RecordTypeA, "10.0.0.2")}},
+		{Delete: []*endpoint.Endpoint{endpoint.NewEndpoint("frantj.cc", endpoint.RecordTypeA, "10.0.0.1")}},
+	} {
+		if err := p.ApplyChanges(ctx, changes); err == nil {
+			t.Fatal("expected error changing read-only record")
+		}
+	}
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("frantj.cc", endpoint.RecordTypeAAAA, "fd00::1")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if records, err := p.Records(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %v", records)
+	}
+}
+
+func TestProviderDefaultTTL(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		p   = newProvider(t)
+	)
+
+	p.DefaultTTL = 300
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+			endpoint.NewEndpointWithTTL("app.frantj.cc", endpoint.RecordTypeA, 60, "10.0.0.6"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := p.Records(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ep := range records {
+		expected := endpoint.TTL(300)
+		if ep.DNSName == "app.frantj.cc" {
+			expected = 60
+		}
+
+		if ep.RecordTTL != expected {
+			t.Fatalf("expected TTL %d for %s, got %d", expected, ep.DNSName, ep.RecordTTL)
+		}
+	}
+
+	if stored, err := p.Store.List(ctx); err != nil {
+		t.Fatal(err)
+	} else if stored[0].RecordTTL.IsConfigured() {
+		t.Fatal("expected stored endpoint to be left without TTL")
+	}
+}
+
+func TestProviderDomainFilter(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		p   = newProvider(t)
+	)
+
+	p.DomainFilter = endpoint.NewDomainFilterWithExclusions([]string{"home.frantj.cc"}, []string{"private.home.frantj.cc"})
+
+	if df, ok := p.GetDomainFilter().(*endpoint.DomainFilter); !ok || !slices.Equal(df.Filters, []string{"home.frantj.cc"}) {
+		t.Fatalf("expected domain filter to be advertised, got %v", p.GetDomainFilter())
+	}
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("app.home.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ep := range []*endpoint.Endpoint{
+		endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		endpoint.NewEndpoint("app.private.home.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+	} {
+		if err := p.ApplyChanges(ctx, &plan.Changes{
+			Create: []*endpoint.Endpoint{
+				endpoint.NewEndpoint("www.home.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+				ep,
+			},
+		}); err == nil {
+			t.Fatalf("expected error for %s", ep.DNSName)
+		}
+	}
+
+	if records, err := p.Records(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(records) != 1 {
+		t.Fatalf("expected rejected changes not to be applied, got %v", records)
+	}
+}
+
+func TestProviderAdjustEndpoints(t *testing.T) {
+	p := newProvider(t)
+	p.DefaultTTL = 300
+
+	adjusted, err := p.AdjustEndpoints([]*endpoint.Endpoint{
+		{DNSName: "WWW.frantj.cc.", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"10.0.0.6", "10.0.0.5", "10.0.0.6"}},
+		{DNSName: "www.frantj.cc", RecordType: endpoint.RecordTypeAAAA, Targets: endpoint.Targets{"fd00:0:0::5"}, RecordTTL: 60},
+		{DNSName: "app.frantj.cc", RecordType: endpoint.RecordTypeCNAME, Targets: endpoint.Targets{"LB.example.com."}},
+		{DNSName: "app.frantj.cc", RecordType: endpoint.RecordTypeTXT, Targets: endpoint.Targets{`"Some.Text."`}},
+		{DNSName: "frantj.cc", RecordType: endpoint.RecordTypeMX, Targets: endpoint.Targets{"10 mail.frantj.cc"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*endpoint.Endpoint{
+		{DNSName: "www.frantj.cc", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"10.0.0.5", "10.0.0.6"}, RecordTTL: 300},
+		{DNSName: "www.frantj.cc", RecordType: endpoint.RecordTypeAAAA, Targets: endpoint.Targets{"fd00::5"}, RecordTTL: 60},
+		{DNSName: "app.frantj.cc", RecordType: endpoint.RecordTypeCNAME, Targets: endpoint.Targets{"lb.example.com"}, RecordTTL: 300},
+		{DNSName: "app.frantj.cc", RecordType: endpoint.RecordTypeTXT, Targets: endpoint.Targets{`"Some.Text."`}, RecordTTL: 300},
+	}
+
+	if len(adjusted) != len(expected) {
+		t.Fatalf("expected %d endpoints, got %d: %v", len(expected), len(adjusted), adjusted)
+	}
+
+	for i := range expected {
+		if adjusted[i].String() != expected[i].String() {
+			t.Fatalf("expected %v, got %v", expected[i], adjusted[i])
+		}
+	}
+}
+
+func TestProviderApplyChangesWildcard(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		p   = newProvider(t)
+	)
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("*.apps.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+			endpoint.NewEndpoint("a-*.apps.frantj.cc", endpoint.RecordTypeTXT, `"heritage=external-dns,external-dns/owner=default"`),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if records, err := p.Records(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(records) != 2 || records[0].DNSName != "*.apps.frantj.cc" {
+		t.Fatalf("expected wildcard record, got %v", records)
+	}
+
+	if !p.Exists("apps.frantj.cc") || p.Exists("www.apps.frantj.cc") {
+		t.Fatal("expected wildcard to make only its parent exist")
+	}
+
+	for _, ep := range []*endpoint.Endpoint{
+		endpoint.NewEndpoint("apps.*.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeCNAME, "*.apps.frantj.cc"),
+	} {
+		if err := p.ApplyChanges(ctx, &plan.Changes{Create: []*endpoint.Endpoint{ep}}); err == nil {
+			t.Fatalf("expected error for %s", ep)
+		}
+	}
+}
+
+func TestProviderRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	p := newProvider(t)
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes through ApplyChanges are served immediately.
+	if eps := p.Lookup("www.frantj.cc"); len(eps) != 1 {
+		t.Fatalf("expected www.frantj.cc to be served, got %v", eps)
+	}
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- p.Run(ctx)
+	}()
+
+	// Changes made to the Store by other means, e.g. by
+	// another replica, are served once Run sees them.
+	if err := p.Store.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for len(p.Lookup("app.frantj.cc")) != 1 {
+		select {
+		case <-timeout:
+			t.Fatal("timed out waiting for app.frantj.cc to be served")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if !p.Exists("frantj.cc") {
+		t.Fatal("expected frantj.cc to exist")
+	}
+
+	cancel()
+
+	if err := <-errC; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+func TestProviderApplyChangesUpdate(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		p   = newProvider(t)
+	)
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+			endpoint.NewEndpointWithTTL("lb.frantj.cc", endpoint.RecordTypeA, 60, "10.0.0.5", "10.0.0.6"),
+			endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeA, "10.0.0.7"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		UpdateOld: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+			endpoint.NewEndpointWithTTL("lb.frantj.cc", endpoint.RecordTypeA, 60, "10.0.0.5", "10.0.0.6"),
+		},
+		UpdateNew: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+			endpoint.NewEndpointWithTTL("lb.frantj.cc", endpoint.RecordTypeA, 300, "10.0.0.6", "10.0.0.7"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "10.0.0.6 lb.frantj.cc www.frantj.cc\n10.0.0.7 app.frantj.cc lb.frantj.cc\n"
+	if actual := readHostsFile(t, p); actual != expected {
+		t.Fatalf("expected hosts file %q, got %q", expected, actual)
+	}
+
+	if eps := p.Lookup("lb.frantj.cc"); len(eps) != 1 || eps[0].RecordTTL != 300 || !slices.Equal(eps[0].Targets, endpoint.Targets{"10.0.0.6", "10.0.0.7"}) {
+		t.Fatalf("expected lb.frantj.cc to be replaced, got %v", eps)
+	}
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		UpdateOld: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+		},
+		UpdateNew: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeCNAME, "lb.frantj.cc"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "10.0.0.6 lb.frantj.cc\n10.0.0.7 app.frantj.cc lb.frantj.cc\n"
+	if actual := readHostsFile(t, p); actual != expected {
+		t.Fatalf("expected hosts file %q, got %q", expected, actual)
+	}
+
+	if eps := p.Lookup("www.frantj.cc"); len(eps) != 1 || eps[0].RecordType != endpoint.RecordTypeCNAME {
+		t.Fatalf("expected www.frantj.cc to be a CNAME, got %v", eps)
+	}
+
+	if records, err := p.Records(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %v", records)
+	}
+}
+
+func TestProviderApplyChangesAtomic(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		p   = newProvider(t)
+	)
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := readHostsFile(t, p)
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("a.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+			endpoint.NewEndpoint("b.frantj.cc", endpoint.RecordTypeA, "10.0.0.7"),
+			endpoint.NewEndpoint("c.frantj.cc", endpoint.RecordTypeA, "fd00::8"),
+		},
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	}); err == nil {
+		t.Fatal("expected error for invalid address")
+	}
+
+	if actual := readHostsFile(t, p); actual != expected {
+		t.Fatalf("expected hosts file %q, got %q", expected, actual)
+	}
+
+	if len(hostsFile(p).Hosts.Hosts) != 1 {
+		t.Fatalf("expected hosts to be unchanged, got %v", hostsFile(p).Hosts.Hosts)
+	}
+
+	if records, err := p.Records(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(records) != 1 || records[0].DNSName != "www.frantj.cc" {
+		t.Fatalf("expected records to be unchanged, got %v", records)
+	}
+}
+
+func TestProviderApplyChangesSetIdentifier(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		p   = newProvider(t)
+	)
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5").WithSetIdentifier("blue"),
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5", "10.0.0.6").WithSetIdentifier("green"),
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeAAAA, "fd00::5"),
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeTXT, `"heritage=external-dns,external-dns/owner=default"`),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeTXT, `"heritage=external-dns,external-dns/owner=default"`),
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5", "10.0.0.6").WithSetIdentifier("green"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "10.0.0.5 www.frantj.cc\nfd00::5 www.frantj.cc\n"
+	if actual := readHostsFile(t, p); actual != expected {
+		t.Fatalf("expected hosts file %q, got %q", expected, actual)
+	}
+
+	eps := p.Lookup("www.frantj.cc")
+	if len(eps) != 2 {
+		t.Fatalf("expected 2 records, got %v", eps)
+	}
+
+	for _, ep := range eps {
+		if ep.RecordType == endpoint.RecordTypeA && ep.SetIdentifier != "blue" {
+			t.Fatalf("expected only the blue A record to remain, got %v", ep)
+		}
+	}
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeCNAME, "blue.frantj.cc").WithSetIdentifier("blue"),
+			endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeCNAME, "green.frantj.cc").WithSetIdentifier("green"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProviderRouting(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		p   = newProvider(t)
+	)
+
+	for _, ep := range []*endpoint.Endpoint{
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5").
+			WithProviderSpecific(externaldns.WeightProperty, "1"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5").
+			WithSetIdentifier("blue").
+			WithProviderSpecific(externaldns.WeightProperty, "-1"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5").
+			WithSetIdentifier("blue").
+			WithProviderSpecific(externaldns.MultiValueProperty, "maybe"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5").
+			WithSetIdentifier("blue").
+			WithProviderSpecific(externaldns.WeightProperty, "1").
+			WithProviderSpecific(externaldns.MultiValueProperty, "true"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5").
+			WithSetIdentifier("blue").
+			WithProviderSpecific(externaldns.FailoverProperty, "tertiary"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5").
+			WithProviderSpecific(externaldns.HealthCheckProperty, "tcp://"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5").
+			WithProviderSpecific(externaldns.HealthCheckProperty, "udp://:53"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeCNAME, "lb.example.com").
+			WithProviderSpecific(externaldns.HealthCheckProperty, "tcp://:443"),
+	} {
+		if err := p.ApplyChanges(ctx, &plan.Changes{Create: []*endpoint.Endpoint{ep}}); err == nil {
+			t.Fatalf("expected error for %s", ep)
+		}
+	}
+
+	adjusted, err := p.AdjustEndpoints([]*endpoint.Endpoint{
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5").
+			WithSetIdentifier("blue").
+			WithProviderSpecific(externaldns.WeightProperty, "010"),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.6").
+			WithSetIdentifier("green").
+			WithProviderSpecific(externaldns.MultiValueProperty, "1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.ApplyChanges(ctx, &plan.Changes{Create: adjusted}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := p.Records(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %v", records)
+	}
+
+	if weight, _ := records[0].GetProviderSpecificProperty(externaldns.WeightProperty); weight != "10" {
+		t.Fatalf("expected weight 10, got %q", weight)
+	}
+
+	if multiValue, _ := records[1].GetProviderSpecificProperty(externaldns.MultiValueProperty); multiValue != "true" {
+		t.Fatalf("expected multi-value true, got %q", multiValue)
+	}
+}
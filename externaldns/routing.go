@@ -0,0 +1,169 @@
+package externaldns
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
+	xslices "github.com/frantjc/x/slices"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+const (
+	// WeightProperty is the provider-specific property, set through the
+	// "external-dns.alpha.kubernetes.io/webhook-weight" annotation, that
+	// makes an Endpoint with a SetIdentifier one of several alternatives
+	// for its name and record type, answered in proportion to its weight.
+	WeightProperty = "webhook/weight"
+	// MultiValueProperty is the provider-specific property, set through the
+	// "external-dns.alpha.kubernetes.io/webhook-multi-value" annotation,
+	// that makes the targets of an Endpoint with a SetIdentifier be
+	// answered together with those of the others for its name and record
+	// type, up to a maximum number of answers.
+	MultiValueProperty = "webhook/multi-value"
+	// FailoverProperty is the provider-specific property, set through the
+	// "external-dns.alpha.kubernetes.io/webhook-failover" annotation, that
+	// makes an Endpoint with a SetIdentifier either the FailoverPrimary or
+	// the FailoverSecondary for its name and record type. The secondary is
+	// only answered while the primary has no healthy targets.
+	FailoverProperty = "webhook/failover"
+	// HealthCheckProperty is the provider-specific property, set through
+	// the "external-dns.alpha.kubernetes.io/webhook-health-check"
+	// annotation, that turns on active health checks of an Endpoint's
+	// targets, e.g. "tcp://:443" or "http://:8080/healthz". The host of
+	// the URL is replaced by each target, but is still sent as the Host
+	// header of HTTP checks if it is set.
+	HealthCheckProperty = "webhook/health-check"
+)
+
+const (
+	FailoverPrimary   = "primary"
+	FailoverSecondary = "secondary"
+)
+
+// Weight returns the weight of ep and whether it has one.
+func Weight(ep *endpoint.Endpoint) (uint32, bool, error) {
+	value, ok := ep.GetProviderSpecificProperty(WeightProperty)
+	if !ok {
+		return 0, false, nil
+	}
+
+	weight, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid weight for %s record %s: %s", ep.RecordType, ep.DNSName, value)
+	}
+
+	return uint32(weight), true, nil
+}
+
+// IsMultiValue reports whether ep is a multi-value answer.
+func IsMultiValue(ep *endpoint.Endpoint) (bool, error) {
+	value, ok := ep.GetProviderSpecificProperty(MultiValueProperty)
+	if !ok {
+		return false, nil
+	}
+
+	multiValue, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid multi-value for %s record %s: %s", ep.RecordType, ep.DNSName, value)
+	}
+
+	return multiValue, nil
+}
+
+// Failover returns the failover role of ep, if it has one.
+func Failover(ep *endpoint.Endpoint) (string, error) {
+	value, ok := ep.GetProviderSpecificProperty(FailoverProperty)
+	if !ok {
+		return "", nil
+	}
+
+	switch role := strings.ToLower(value); role {
+	case FailoverPrimary, FailoverSecondary:
+		return role, nil
+	}
+
+	return "", fmt.Errorf("invalid failover for %s record %s: %s", ep.RecordType, ep.DNSName, value)
+}
+
+// HealthCheck returns the URL of the health check of ep's targets, if it
+// has one.
+func HealthCheck(ep *endpoint.Endpoint) (*url.URL, error) {
+	value, ok := ep.GetProviderSpecificProperty(HealthCheckProperty)
+	if !ok {
+		return nil, nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health check for %s record %s: %w", ep.RecordType, ep.DNSName, err)
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		if u.Port() == "" {
+			return nil, fmt.Errorf("health check for %s record %s must have a port: %s", ep.RecordType, ep.DNSName, value)
+		}
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported health check for %s record %s: %s", ep.RecordType, ep.DNSName, value)
+	}
+
+	return u, nil
+}
+
+// validateRouting checks that ep's routing properties are well-formed,
+// that at most one routing policy is used and only together with a
+// SetIdentifier, and that only addresses are health checked.
+func validateRouting(ep *endpoint.Endpoint) error {
+	_, weighted, err := Weight(ep)
+	if err != nil {
+		return err
+	}
+
+	multiValue, err := IsMultiValue(ep)
+	if err != nil {
+		return err
+	}
+
+	failover, err := Failover(ep)
+	if err != nil {
+		return err
+	}
+
+	if routes := xslices.Filter([]bool{weighted, multiValue, failover != ""}, func(b bool, _ int) bool {
+		return b
+	}); len(routes) > 1 {
+		return fmt.Errorf("%s record %s can only be one of weighted, multi-value or failover", ep.RecordType, ep.DNSName)
+	} else if len(routes) > 0 && ep.SetIdentifier == "" {
+		return fmt.Errorf("%s record %s must have a set identifier to be weighted, multi-value or failover", ep.RecordType, ep.DNSName)
+	}
+
+	if check, err := HealthCheck(ep); err != nil {
+		return err
+	} else if check != nil && !store.IsAddressRecordType(ep.RecordType) {
+		return fmt.Errorf("health checks are only supported for A and AAAA records, not %s record %s", ep.RecordType, ep.DNSName)
+	}
+
+	return nil
+}
+
+// adjustRouting rewrites ep's routing properties in canonical form so
+// that they compare equal to what Records returns.
+func adjustRouting(ep *endpoint.Endpoint) {
+	if weight, ok, err := Weight(ep); err == nil && ok {
+		ep.SetProviderSpecificProperty(WeightProperty, strconv.FormatUint(uint64(weight), 10))
+	}
+
+	if failover, err := Failover(ep); err == nil && failover != "" {
+		ep.SetProviderSpecificProperty(FailoverProperty, failover)
+	}
+
+	if _, ok := ep.GetProviderSpecificProperty(MultiValueProperty); ok {
+		if multiValue, err := IsMultiValue(ep); err == nil {
+			ep.SetProviderSpecificProperty(MultiValueProperty, strconv.FormatBool(multiValue))
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package externaldns
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/hosts"
+	"github.com/frantjc/external-dns-dnsserver-webhook/internal/logutil"
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
+	"github.com/miekg/dns"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// RecordTypePTR is the record type of the Endpoints that snapshots
+// synthesize for the addresses of A and AAAA Endpoints.
+const RecordTypePTR = "PTR"
+
+// snapshot is an immutable index of the Endpoints that a Provider serves.
+type snapshot struct {
+	// endpoints are the Endpoints to serve by their lowercased DNSName.
+	endpoints map[string][]*endpoint.Endpoint
+	// names are the lowercased DNSNames of the Endpoints
+	// to serve, along with every one of their ancestors.
+	names map[string]struct{}
+	// list is every Endpoint to serve.
+	list []*endpoint.Endpoint
+	// serial increases with every change, see Provider.Serial.
+	serial uint32
+	// journal is the changes that led up to this snapshot, see Version.
+	journal []Delta
+}
+
+// newSnapshot indexes the Endpoints that p serves out of endpoints.
+func (p *Provider) newSnapshot(endpoints []*endpoint.Endpoint) *snapshot {
+	s := &snapshot{
+		endpoints: map[string][]*endpoint.Endpoint{},
+		names:     map[string]struct{}{},
+	}
+
+	ptrs := map[string]*endpoint.Endpoint{}
+
+	for _, ep := range endpoints {
+		if p.HideRegistryTXT && IsRegistryTXT(ep) {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSuffix(ep.DNSName, "."))
+		s.endpoints[name] = append(s.endpoints[name], ep)
+		s.list = append(s.list, ep)
+
+		for i, end := 0, false; !end; i, end = dns.NextLabel(name, i) {
+			s.names[name[i:]] = struct{}{}
+		}
+
+		if !store.IsAddressRecordType(ep.RecordType) || hosts.IsWildcard(name) {
+			continue
+		}
+
+		for _, target := range ep.Targets {
+			addr, err := dns.ReverseAddr(target)
+			if err != nil {
+				continue
+			}
+			addr = strings.TrimSuffix(addr, ".")
+
+			if ptr, ok := ptrs[addr]; !ok {
+				ptrs[addr] = endpoint.NewEndpointWithTTL(addr, RecordTypePTR, ep.RecordTTL, name)
+			} else if !slices.Contains(ptr.Targets, name) {
+				ptr.Targets = append(ptr.Targets, name)
+
+				// Use the lowest TTL of the names it points to.
+				if ep.RecordTTL.IsConfigured() && (!ptr.RecordTTL.IsConfigured() || ep.RecordTTL < ptr.RecordTTL) {
+					ptr.RecordTTL = ep.RecordTTL
+				}
+			}
+		}
+	}
+
+	for _, addr := range slices.Sorted(maps.Keys(ptrs)) {
+		s.endpoints[addr] = append(s.endpoints[addr], ptrs[addr])
+		s.list = append(s.list, ptrs[addr])
+	}
+
+	return s
+}
+
+// lookup returns the Endpoints whose DNSName is name.
+func (s *snapshot) lookup(name string) []*endpoint.Endpoint {
+	return slices.Clone(s.endpoints[strings.ToLower(strings.TrimSuffix(name, "."))])
+}
+
+// exists reports whether there are Endpoints whose
+// DNSName is name or a subdomain of it.
+func (s *snapshot) exists(name string) bool {
+	_, ok := s.names[strings.ToLower(strings.TrimSuffix(name, "."))]
+	return ok
+}
+
+// Run keeps the records that Lookup and Exists answer from up-to-date
+// with changes made to Store other than through ApplyChanges, e.g. by
+// other replicas, until ctx is done.
+func (p *Provider) Run(ctx context.Context) error {
+	watch, err := p.store().Watch(ctx)
+	if err != nil {
+		return err
+	}
+
+	log := logutil.SloggerFrom(ctx)
+
+	if err := p.refresh(ctx); err != nil {
+		log.Error("failed to refresh records", "err", err)
+	}
+
+	for range watch {
+		if err := p.refresh(ctx); err != nil {
+			log.Error("failed to refresh records", "err", err)
+		}
+	}
+
+	return ctx.Err()
+}
+
+// refresh swaps in a new snapshot of the Endpoints in Store.
+func (p *Provider) refresh(ctx context.Context) error {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	endpoints, err := p.store().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		prev = p.snapshot.Load()
+		s    = p.newSnapshot(slices.Concat(p.ReadOnlyEndpoints, endpoints))
+	)
+
+	if prev == nil {
+		s.serial = nextSerial(nil)
+	} else if delta := diff(prev, s); delta != nil {
+		s.serial = nextSerial(prev)
+		delta.From, delta.To = prev.serial, s.serial
+		s.journal = append(slices.Clone(prev.journal), *delta)
+		if n := p.journalLen(); len(s.journal) > n {
+			s.journal = s.journal[len(s.journal)-n:]
+		}
+	} else {
+		// Nothing changed, e.g. when Run sees a change
+		// that ApplyChanges has already refreshed for.
+		s.serial, s.journal = prev.serial, prev.journal
+		p.snapshot.Store(s)
+		return nil
+	}
+
+	p.snapshot.Store(s)
+	p.watchers.Notify()
+
+	return nil
+}
+
+// Touch advances the serial of the records that Lookup and Exists answer
+// from without changing them, e.g. so that secondary DNS servers transfer
+// signed zones anew before the signatures that they have expire.
+func (p *Provider) Touch() {
+	if p == nil {
+		return
+	}
+
+	// Take the first snapshot before refreshMu is held, if there is none yet.
+	p.load()
+
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	prev := p.snapshot.Load()
+	if prev == nil {
+		return
+	}
+
+	s := *prev
+	s.serial = nextSerial(prev)
+	s.journal = append(slices.Clone(prev.journal), Delta{From: prev.serial, To: s.serial})
+	if n := p.journalLen(); len(s.journal) > n {
+		s.journal = s.journal[len(s.journal)-n:]
+	}
+
+	p.snapshot.Store(&s)
+	p.watchers.Notify()
+}
+
+// nextSerial returns the serial of the snapshot after prev. Serials are
+// the Unix time at which they were taken so that they keep increasing
+// across restarts, unless changes are taken more than once a second.
+func nextSerial(prev *snapshot) uint32 {
+	serial := uint32(time.Now().Unix())
+	if prev != nil && prev.serial >= serial {
+		return prev.serial + 1
+	}
+
+	return serial
+}
+
+// Watch returns a channel that receives whenever the Endpoints that Lookup
+// and Exists answer from change until ctx is done, at which point it is closed.
+func (p *Provider) Watch(ctx context.Context) (<-chan struct{}, error) {
+	return p.watchers.Watch(ctx), nil
+}
+
+// Serial returns the serial number of the records that Lookup and
+// Exists answer from, which increases whenever they change, e.g. for
+// the SOA record of a zone that they are in.
+func (p *Provider) Serial() uint32 {
+	if p == nil {
+		return 0
+	}
+
+	return p.load().serial
+}
+
+// load returns the current snapshot, taking one if there is none yet.
+func (p *Provider) load() *snapshot {
+	if s := p.snapshot.Load(); s != nil {
+		return s
+	}
+
+	// A failing Store has nothing to answer with.
+	if err := p.refresh(context.TODO()); err != nil {
+		s := p.newSnapshot(p.ReadOnlyEndpoints)
+		s.serial = nextSerial(nil)
+		return s
+	}
+
+	return p.snapshot.Load()
+}
@@ -0,0 +1,171 @@
+package externaldns
+
+import (
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/zonefile"
+	"github.com/miekg/dns"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// EndpointsFromZone groups the records of each name and type in z into one
+// Endpoint. Records of types that are not served, e.g. SOA and NS, are skipped.
+func EndpointsFromZone(z *zonefile.Zone) []*endpoint.Endpoint {
+	var (
+		endpoints = []*endpoint.Endpoint{}
+		index     = map[endpoint.EndpointKey]*endpoint.Endpoint{}
+	)
+
+	if z == nil {
+		return endpoints
+	}
+
+	for _, rr := range z.Records {
+		var (
+			hdr        = rr.Header()
+			recordType = dns.TypeToString[hdr.Rrtype]
+			targets    = []string{}
+		)
+
+		switch rr := rr.(type) {
+		case *dns.A:
+			targets = append(targets, rr.A.String())
+		case *dns.AAAA:
+			targets = append(targets, rr.AAAA.String())
+		case *dns.CNAME:
+			targets = append(targets, strings.ToLower(strings.TrimSuffix(rr.Target, ".")))
+		case *dns.TXT:
+			targets = append(targets, txtTarget(rr.Txt))
+		case *dns.PTR:
+			targets = append(targets, strings.ToLower(strings.TrimSuffix(rr.Ptr, ".")))
+		default:
+			continue
+		}
+
+		name := strings.TrimSuffix(hdr.Name, ".")
+		key := endpoint.EndpointKey{DNSName: name, RecordType: recordType}
+
+		if ep, ok := index[key]; ok {
+			ep.Targets = append(ep.Targets, targets...)
+
+			// A record set has one TTL, so use the lowest.
+			if endpoint.TTL(hdr.Ttl) < ep.RecordTTL {
+				ep.RecordTTL = endpoint.TTL(hdr.Ttl)
+			}
+
+			continue
+		}
+
+		ep := endpoint.NewEndpointWithTTL(name, recordType, endpoint.TTL(hdr.Ttl), targets...)
+		index[key] = ep
+		endpoints = append(endpoints, ep)
+	}
+
+	return endpoints
+}
+
+// ZoneFromEndpoints returns a zone with origin holding the records of
+// endpoints, those without a TTL configured having ttl. Routing policies
+// cannot be expressed in a zone file, so alternatives for the same name,
+// e.g. weighted records, are all written out.
+func ZoneFromEndpoints(origin string, ttl endpoint.TTL, endpoints []*endpoint.Endpoint) *zonefile.Zone {
+	z := &zonefile.Zone{
+		Origin:  strings.ToLower(dns.Fqdn(origin)),
+		TTL:     uint32(ttl),
+		Records: []dns.RR{},
+	}
+
+	for _, ep := range endpoints {
+		name := strings.ToLower(dns.Fqdn(ep.DNSName))
+		if !dns.IsSubDomain(z.Origin, name) {
+			continue
+		}
+
+		hdr := dns.RR_Header{
+			Name:  name,
+			Class: dns.ClassINET,
+			Ttl:   z.TTL,
+		}
+
+		if ep.RecordTTL.IsConfigured() {
+			hdr.Ttl = uint32(ep.RecordTTL)
+		}
+
+		for _, target := range ep.Targets {
+			var rr dns.RR
+
+			switch ep.RecordType {
+			case endpoint.RecordTypeA:
+				hdr.Rrtype = dns.TypeA
+				rr = &dns.A{Hdr: hdr, A: net.ParseIP(target)}
+			case endpoint.RecordTypeAAAA:
+				hdr.Rrtype = dns.TypeAAAA
+				rr = &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP(target)}
+			case endpoint.RecordTypeCNAME:
+				hdr.Rrtype = dns.TypeCNAME
+				rr = &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(target)}
+			case endpoint.RecordTypeTXT:
+				hdr.Rrtype = dns.TypeTXT
+				rr = &dns.TXT{Hdr: hdr, Txt: TXTStrings(target)}
+			case RecordTypePTR:
+				hdr.Rrtype = dns.TypePTR
+				rr = &dns.PTR{Hdr: hdr, Ptr: dns.Fqdn(target)}
+			default:
+				continue
+			}
+
+			if !slices.ContainsFunc(z.Records, func(ex dns.RR) bool {
+				return dns.IsDuplicate(ex, rr)
+			}) {
+				z.Records = append(z.Records, rr)
+			}
+		}
+	}
+
+	return z
+}
+
+// TXTStrings splits target into the character-strings of a TXT record in
+// the escaped form that dns.TXT expects. Targets in presentation format,
+// e.g. `"heritage=external-dns,..."` or `"part one" "part two"`, are
+// unquoted; any other target is taken as-is. Strings longer than 255
+// bytes are split to fit.
+func TXTStrings(target string) []string {
+	if len(target) >= 2 && strings.HasPrefix(target, `"`) && strings.HasSuffix(target, `"`) {
+		if rr, err := dns.NewRR(". IN TXT " + target); err == nil {
+			if t, ok := rr.(*dns.TXT); ok {
+				return t.Txt
+			}
+		}
+	}
+
+	strs := []string{}
+	for {
+		s := target
+		if len(s) > 255 {
+			s = s[:255]
+		}
+
+		strs = append(strs, txtEscaper.Replace(s))
+
+		if target = target[len(s):]; target == "" {
+			return strs
+		}
+	}
+}
+
+var txtEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// txtTarget is the inverse of TXTStrings. A single character-string is
+// unescaped, while several are kept in presentation format.
+func txtTarget(strs []string) string {
+	if len(strs) == 1 {
+		return txtUnescaper.Replace(strs[0])
+	}
+
+	return `"` + strings.Join(strs, `" "`) + `"`
+}
+
+var txtUnescaper = strings.NewReplacer(`\\`, `\`, `\"`, `"`)
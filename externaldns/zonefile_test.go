@@ -0,0 +1,92 @@
+package externaldns_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/zonefile"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestEndpointsFromZone(t *testing.T) {
+	z, err := zonefile.Decode(strings.NewReader(`$TTL 300
+@	IN	SOA	ns hostmaster 1 7200 1800 1209600 60
+@	IN	NS	ns
+@	IN	A	10.0.0.1
+@	60	IN	A	10.0.0.2
+www	IN	CNAME	@
+txt	IN	TXT	"heritage=external-dns,external-dns/owner=default"
+`), "Frantj.cc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		actual   = externaldns.EndpointsFromZone(z)
+		expected = []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("frantj.cc", endpoint.RecordTypeA, 60, "10.0.0.1", "10.0.0.2"),
+			endpoint.NewEndpointWithTTL("www.frantj.cc", endpoint.RecordTypeCNAME, 300, "frantj.cc"),
+			endpoint.NewEndpointWithTTL("txt.frantj.cc", endpoint.RecordTypeTXT, 300, "heritage=external-dns,external-dns/owner=default"),
+		}
+	)
+
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d endpoints, got %d: %v", len(expected), len(actual), actual)
+	}
+
+	for i := range expected {
+		if actual[i].DNSName != expected[i].DNSName ||
+			actual[i].RecordType != expected[i].RecordType ||
+			actual[i].RecordTTL != expected[i].RecordTTL ||
+			!actual[i].Targets.Same(expected[i].Targets) {
+			t.Fatalf("expected %v, got %v", expected[i], actual[i])
+		}
+	}
+}
+
+func TestZoneFromEndpoints(t *testing.T) {
+	endpoints := []*endpoint.Endpoint{
+		endpoint.NewEndpoint("frantj.cc", endpoint.RecordTypeA, "10.0.0.1"),
+		endpoint.NewEndpointWithTTL("www.frantj.cc", endpoint.RecordTypeCNAME, 60, "frantj.cc"),
+		endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeAAAA, "fd00::1"),
+		endpoint.NewEndpoint("txt.frantj.cc", endpoint.RecordTypeTXT, `"part one" "part two"`),
+		endpoint.NewEndpoint("frantj.io", endpoint.RecordTypeA, "10.0.0.2"),
+	}
+
+	z := externaldns.ZoneFromEndpoints("frantj.cc", 300, endpoints)
+	if len(z.Records) != 4 {
+		t.Fatalf("expected records outside of the origin to be left out, got %v", z.Records)
+	}
+
+	b := new(bytes.Buffer)
+	if err := z.Encode(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoding what was encoded gives back the same Endpoints.
+	decoded, err := zonefile.Decode(bytes.NewReader(b.Bytes()), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := externaldns.EndpointsFromZone(decoded)
+	if len(actual) != 4 {
+		t.Fatalf("expected 4 endpoints, got %d: %v", len(actual), actual)
+	}
+
+	for i, ep := range endpoints[:4] {
+		ttl := ep.RecordTTL
+		if !ttl.IsConfigured() {
+			ttl = 300
+		}
+
+		if actual[i].DNSName != ep.DNSName ||
+			actual[i].RecordType != ep.RecordType ||
+			actual[i].RecordTTL != ttl ||
+			!actual[i].Targets.Same(ep.Targets) {
+			t.Fatalf("expected %v, got %v\n%s", ep, actual[i], b.String())
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Package health actively checks the targets of Endpoints that have an
+// externaldns.HealthCheckProperty and leaves unhealthy ones out of lookups.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/internal/logutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+const (
+	// DefaultInterval is the time between health checks if none is configured.
+	DefaultInterval = 10 * time.Second
+	// DefaultTimeout is the timeout of a health check if none is configured.
+	DefaultTimeout = 5 * time.Second
+)
+
+// TargetHealthy reports the result of the most recent health check of
+// each target.
+var TargetHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "coredns",
+	Subsystem: "externaldns",
+	Name:      "target_healthy",
+	Help:      "Whether a health-checked target is healthy (1) or not (0).",
+}, []string{"name", "type", "set_identifier", "target"})
+
+// Source provides the Endpoints to check and to look up.
+type Source interface {
+	Records(ctx context.Context) ([]*endpoint.Endpoint, error)
+	Lookup(name string) []*endpoint.Endpoint
+	Exists(name string) bool
+}
+
+// Checker wraps a Source, leaving the targets that failed their most
+// recent health check out of its lookups. An Endpoint without healthy
+// targets is left out altogether, unless no Endpoint of its name and
+// record type would be left, in which case all of them are kept.
+type Checker struct {
+	Source   Source
+	Interval time.Duration
+	Timeout  time.Duration
+
+	mu     sync.RWMutex
+	status map[check]bool
+	gauges map[gauge]bool
+}
+
+type check struct {
+	url    string
+	target string
+}
+
+// gauge is the labels of a target in TargetHealthy.
+type gauge struct {
+	name, recordType, setIdentifier, target string
+}
+
+// Lookup returns the Endpoints whose DNSName is name with only their
+// healthy targets.
+func (c *Checker) Lookup(name string) []*endpoint.Endpoint {
+	var (
+		eps      = c.Source.Lookup(name)
+		filtered = make([]*endpoint.Endpoint, len(eps))
+		healthy  = map[string]bool{}
+	)
+
+	c.mu.RLock()
+	for i, ep := range eps {
+		u, err := externaldns.HealthCheck(ep)
+		if err != nil || u == nil {
+			filtered[i] = ep
+			healthy[ep.RecordType] = true
+			continue
+		}
+
+		targets := endpoint.Targets{}
+		for _, target := range ep.Targets {
+			// Targets that have not been checked yet are healthy.
+			if ok, checked := c.status[check{u.String(), target}]; ok || !checked {
+				targets = append(targets, target)
+			}
+		}
+
+		if len(targets) > 0 {
+			filtered[i] = ep.DeepCopy()
+			filtered[i].Targets = targets
+			healthy[ep.RecordType] = true
+		}
+	}
+	c.mu.RUnlock()
+
+	lookup := []*endpoint.Endpoint{}
+	for i, ep := range eps {
+		if filtered[i] != nil {
+			lookup = append(lookup, filtered[i])
+		} else if !healthy[ep.RecordType] {
+			lookup = append(lookup, ep)
+		}
+	}
+
+	return lookup
+}
+
+// Exists implements coredns.Source.
+func (c *Checker) Exists(name string) bool {
+	return c.Source.Exists(name)
+}
+
+// Run checks the targets of the Source every c.Interval until ctx is done.
+func (c *Checker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.interval())
+	defer ticker.Stop()
+
+	for {
+		if err := c.CheckAll(ctx); err != nil {
+			logutil.SloggerFrom(ctx).Error("health checks failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll checks the targets of every Endpoint of the Source that has a
+// health check once and records the results.
+func (c *Checker) CheckAll(ctx context.Context) error {
+	records, err := c.Source.Records(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		log    = logutil.SloggerFrom(ctx)
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		status = map[check]bool{}
+		gauges = map[gauge]bool{}
+	)
+
+	for _, ep := range records {
+		u, err := externaldns.HealthCheck(ep)
+		if err != nil || u == nil {
+			continue
+		}
+
+		for _, target := range ep.Targets {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				var (
+					key     = check{u.String(), target}
+					labels  = gauge{ep.DNSName, ep.RecordType, ep.SetIdentifier, target}
+					err     = c.check(ctx, u, target)
+					healthy = err == nil
+					value   = 0.0
+				)
+
+				mu.Lock()
+				status[key] = healthy
+				gauges[labels] = true
+				mu.Unlock()
+
+				// Each gauge is set as its check completes rather than
+				// reset beforehand so that scrapes in between see the
+				// result of the previous check rather than nothing.
+				if healthy {
+					value = 1
+				}
+				TargetHealthy.WithLabelValues(labels.name, labels.recordType, labels.setIdentifier, labels.target).Set(value)
+
+				c.mu.RLock()
+				previous, checked := c.status[key]
+				c.mu.RUnlock()
+
+				if !healthy && (previous || !checked) {
+					log.Warn("target is unhealthy", "name", ep.DNSName, "target", target, "check", u.String(), "err", err)
+				} else if healthy && checked && !previous {
+					log.Info("target is healthy", "name", ep.DNSName, "target", target, "check", u.String())
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Targets that are no longer checked are no longer reported.
+	for labels := range c.gauges {
+		if !gauges[labels] {
+			TargetHealthy.DeleteLabelValues(labels.name, labels.recordType, labels.setIdentifier, labels.target)
+		}
+	}
+
+	c.status = status
+	c.gauges = gauges
+
+	return nil
+}
+
+// check checks target with the health check at u, connecting to target
+// in place of u's host.
+func (c *Checker) check(ctx context.Context, u *url.URL, target string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	dialer := &net.Dialer{}
+
+	switch u.Scheme {
+	case "tcp":
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(target, u.Port()))
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	case "http", "https":
+		v := *u
+		if v.Hostname() == "" {
+			v.Host = target
+			if ip := net.ParseIP(target); ip != nil && ip.To4() == nil {
+				v.Host = "[" + target + "]"
+			}
+
+			if port := u.Port(); port != "" {
+				v.Host = net.JoinHostPort(target, port)
+			}
+		}
+
+		// Connect to target whatever the host of the URL is so that
+		// it is still used for the Host header and TLS verification.
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					_, port, err := net.SplitHostPort(addr)
+					if err != nil {
+						return nil, err
+					}
+
+					return dialer.DialContext(ctx, network, net.JoinHostPort(target, port))
+				},
+				DisableKeepAlives: true,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.String(), nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("unexpected status %s", res.Status)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unsupported health check: %s", u)
+}
+
+func (c *Checker) interval() time.Duration {
+	if c.Interval > 0 {
+		return c.Interval
+	}
+
+	return DefaultInterval
+}
+
+func (c *Checker) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+
+	return DefaultTimeout
+}
@@ -0,0 +1,182 @@
+package health_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/externaldns"
+	"github.com/frantjc/external-dns-dnsserver-webhook/health"
+	"github.com/frantjc/external-dns-dnsserver-webhook/hosts"
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func newChecker(t *testing.T, eps ...*endpoint.Endpoint) *health.Checker {
+	t.Helper()
+
+	p := &externaldns.Provider{
+		Store: &store.HostsFile{
+			File:  filepath.Join(t.TempDir(), "hosts"),
+			Hosts: &hosts.Hosts{},
+		},
+	}
+
+	if err := p.ApplyChanges(context.TODO(), &plan.Changes{Create: eps}); err != nil {
+		t.Fatal(err)
+	}
+
+	return &health.Checker{Source: p}
+}
+
+func port(t *testing.T, rawURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u.Port()
+}
+
+func targets(eps []*endpoint.Endpoint) []string {
+	targets := []string{}
+	for _, ep := range eps {
+		targets = append(targets, ep.Targets...)
+	}
+	slices.Sort(targets)
+	return targets
+}
+
+func TestCheckerHTTP(t *testing.T) {
+	var (
+		ctx     = context.TODO()
+		healthy = true
+		srv     = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/healthz" || !strings.HasPrefix(r.Host, "app.frantj.cc:") || !healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+	)
+	defer srv.Close()
+
+	// Nothing listens on 127.0.0.2.
+	c := newChecker(t,
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "127.0.0.1", "127.0.0.2").
+			WithProviderSpecific(externaldns.HealthCheckProperty, "http://app.frantj.cc:"+port(t, srv.URL)+"/healthz"),
+	)
+
+	if actual := targets(c.Lookup("www.frantj.cc")); len(actual) != 2 {
+		t.Fatalf("expected unchecked targets to be healthy, got %v", actual)
+	}
+
+	if err := c.CheckAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := targets(c.Lookup("www.frantj.cc")); !slices.Equal(actual, []string{"127.0.0.1"}) {
+		t.Fatalf("expected only 127.0.0.1 to be healthy, got %v", actual)
+	}
+
+	if gauge := testutil.ToFloat64(health.TargetHealthy.WithLabelValues("www.frantj.cc", endpoint.RecordTypeA, "", "127.0.0.2")); gauge != 0 {
+		t.Fatalf("expected 127.0.0.2 to be reported unhealthy, got %v", gauge)
+	}
+
+	if gauge := testutil.ToFloat64(health.TargetHealthy.WithLabelValues("www.frantj.cc", endpoint.RecordTypeA, "", "127.0.0.1")); gauge != 1 {
+		t.Fatalf("expected 127.0.0.1 to be reported healthy, got %v", gauge)
+	}
+
+	healthy = false
+
+	if err := c.CheckAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// With no healthy targets left, all of them are answered.
+	if actual := targets(c.Lookup("www.frantj.cc")); len(actual) != 2 {
+		t.Fatalf("expected all targets when none is healthy, got %v", actual)
+	}
+}
+
+func TestCheckerTCPFailover(t *testing.T) {
+	ctx := context.TODO()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	check := "tcp://:" + port(t, "tcp://"+l.Addr().String())
+
+	c := newChecker(t,
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "127.0.0.2").
+			WithSetIdentifier("primary").
+			WithProviderSpecific(externaldns.FailoverProperty, externaldns.FailoverPrimary).
+			WithProviderSpecific(externaldns.HealthCheckProperty, check),
+		endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "127.0.0.1").
+			WithSetIdentifier("secondary").
+			WithProviderSpecific(externaldns.FailoverProperty, externaldns.FailoverSecondary).
+			WithProviderSpecific(externaldns.HealthCheckProperty, check),
+	)
+
+	if err := c.CheckAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	eps := c.Lookup("www.frantj.cc")
+	if len(eps) != 1 || eps[0].SetIdentifier != "secondary" {
+		t.Fatalf("expected to fail over to the secondary, got %v", eps)
+	}
+}
+
+func TestCheckerGauges(t *testing.T) {
+	ctx := context.TODO()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var (
+		check = "tcp://:" + port(t, "tcp://"+l.Addr().String())
+		gone  = endpoint.NewEndpoint("gone.frantj.cc", endpoint.RecordTypeA, "127.0.0.1").
+			WithProviderSpecific(externaldns.HealthCheckProperty, check)
+		c = newChecker(t,
+			endpoint.NewEndpoint("kept.frantj.cc", endpoint.RecordTypeA, "127.0.0.1").
+				WithProviderSpecific(externaldns.HealthCheckProperty, check),
+			gone,
+		)
+	)
+
+	if err := c.CheckAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	before := testutil.CollectAndCount(health.TargetHealthy)
+
+	if err := c.Source.(*externaldns.Provider).ApplyChanges(ctx, &plan.Changes{Delete: []*endpoint.Endpoint{gone}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.CheckAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the gauge of the target that is gone is deleted.
+	if after := testutil.CollectAndCount(health.TargetHealthy); after != before-1 {
+		t.Fatalf("expected %d gauges, got %d", before-1, after)
+	} else if gauge := testutil.ToFloat64(health.TargetHealthy.WithLabelValues("kept.frantj.cc", endpoint.RecordTypeA, "", "127.0.0.1")); gauge != 1 {
+		t.Fatalf("expected kept.frantj.cc to still be reported healthy, got %v", gauge)
+	}
+}
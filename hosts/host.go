@@ -29,7 +29,18 @@ type Hosts struct {
 	Hosts []Host
 }
 
-var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_-]+)*$`)
+var hostnameRegexp = regexp.MustCompile(`^(\*\.)?[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_-]+)*$`)
+
+// IsHostname reports whether hostname is valid in a hosts file.
+// The leftmost label of hostname may be a wildcard, e.g. "*.frantj.cc".
+func IsHostname(hostname string) bool {
+	return hostnameRegexp.MatchString(hostname)
+}
+
+// IsWildcard reports whether the leftmost label of hostname is a wildcard.
+func IsWildcard(hostname string) bool {
+	return strings.HasPrefix(hostname, "*.")
+}
 
 func Decode(r io.Reader) (*Hosts, error) {
 	var (
@@ -101,7 +112,7 @@ func (hs *Hosts) Add(g Host) (modified bool) {
 
 			hs.Hosts[i] = Host{
 				IP:        g.IP,
-				Hostnames: append(h.Hostnames, g.Hostnames...),
+				Hostnames: append(slices.Clone(h.Hostnames), g.Hostnames...),
 			}
 
 			return true
@@ -121,21 +132,20 @@ func (hs *Hosts) Remove(g Host) (modified bool) {
 	for i, h := range hs.Hosts {
 		if g.IP.Equal(h.IP) {
 			hs.Hosts[i] = Host{
-				IP: g.IP,
+				IP: h.IP,
 				Hostnames: xslices.Filter(h.Hostnames, func(hostname string, _ int) bool {
 					return !slices.Contains(g.Hostnames, hostname)
 				}),
 			}
 
-			return len(h.Hostnames) != len(hs.Hosts[i].Hostnames)
+			modified = len(h.Hostnames) != len(hs.Hosts[i].Hostnames)
+			break
 		}
 	}
 
-	hosts := xslices.Filter(hs.Hosts, func(h Host, _ int) bool {
+	hs.Hosts = xslices.Filter(hs.Hosts, func(h Host, _ int) bool {
 		return len(h.Hostnames) > 0
 	})
-	modified = len(hosts) != len(hs.Hosts)
-	hs.Hosts = hosts
 
 	return
 }
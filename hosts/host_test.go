@@ -55,3 +55,23 @@ func TestHosts(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestHostsWildcard(t *testing.T) {
+	h, err := hosts.Decode(bytes.NewReader([]byte("10.0.0.1 *.apps.frantj.cc apps.frantj.cc")))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if len(h.Hosts) != 1 || !hosts.IsWildcard(h.Hosts[0].Hostnames[0]) {
+		t.Error("expected wildcard hostname, got", h.Hosts)
+		t.FailNow()
+	}
+
+	for _, hostname := range []string{"*", "apps.*.frantj.cc", "*apps.frantj.cc", "*.*.frantj.cc"} {
+		if _, err := hosts.Decode(bytes.NewReader([]byte("10.0.0.1 " + hostname))); err == nil {
+			t.Error("expected error for", hostname)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+var endpointsBucket = []byte("endpoints")
+
+// Bolt is a Store that persists Endpoints to a bbolt database, which
+// keeps them crash-safe and only reads those that are needed from disk.
+type Bolt struct {
+	db       *bbolt.DB
+	watchers Watchers
+}
+
+var _ Store = &Bolt{}
+
+// OpenBolt opens the bbolt database at path, creating it if need be.
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(endpointsBucket)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+
+	return &Bolt{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Bolt) Close() error {
+	return s.db.Close()
+}
+
+// Get implements Store.
+func (s *Bolt) Get(_ context.Context, name string) ([]*endpoint.Endpoint, error) {
+	var (
+		eps    = []*endpoint.Endpoint{}
+		prefix = append([]byte(normalizeName(name)), 0)
+	)
+
+	if err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(endpointsBucket).Cursor()
+
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			ep := &endpoint.Endpoint{}
+			if err := json.Unmarshal(v, ep); err != nil {
+				return fmt.Errorf("decode %q: %w", k, err)
+			}
+
+			eps = append(eps, ep)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return eps, nil
+}
+
+// List implements Store.
+func (s *Bolt) List(_ context.Context) ([]*endpoint.Endpoint, error) {
+	eps := []*endpoint.Endpoint{}
+
+	if err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(endpointsBucket).ForEach(func(k, v []byte) error {
+			ep := &endpoint.Endpoint{}
+			if err := json.Unmarshal(v, ep); err != nil {
+				return fmt.Errorf("decode %q: %w", k, err)
+			}
+
+			eps = append(eps, ep)
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return eps, nil
+}
+
+// Apply implements Store. All changes are made in one transaction.
+func (s *Bolt) Apply(_ context.Context, changes *Changes) error {
+	if changes == nil {
+		return nil
+	}
+
+	if err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(endpointsBucket)
+
+		for _, ep := range changes.Delete {
+			if err := b.Delete(boltKey(ep)); err != nil {
+				return err
+			}
+		}
+
+		for _, ep := range changes.Put {
+			v, err := json.Marshal(ep)
+			if err != nil {
+				return err
+			}
+
+			if err := b.Put(boltKey(ep), v); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	s.watchers.Notify()
+
+	return nil
+}
+
+// Watch implements Store.
+func (s *Bolt) Watch(ctx context.Context) (<-chan struct{}, error) {
+	return s.watchers.Watch(ctx), nil
+}
+
+// boltKey returns the key of ep in the database, which sorts the
+// Endpoints of each name together so that Get can seek to them.
+func boltKey(ep *endpoint.Endpoint) []byte {
+	key := Key(ep)
+	return []byte(key.DNSName + "\x00" + key.RecordType + "\x00" + key.SetIdentifier)
+}
@@ -0,0 +1,36 @@
+package store_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
+)
+
+func TestBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.db")
+
+	s, err := store.OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, s)
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = store.OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if eps, err := s.List(context.TODO()); err != nil {
+		t.Fatal(err)
+	} else if len(eps) != 4 {
+		t.Fatalf("expected 4 endpoints to be persisted, got %v", eps)
+	}
+}
@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// ConfigMapKey is the key of the ConfigMap's data that Endpoints are kept at.
+const ConfigMapKey = "endpoints.json"
+
+// ConfigMap is a Store that keeps Endpoints in a Kubernetes ConfigMap so
+// that several replicas can share them. Writes use the ConfigMap's
+// resourceVersion for optimistic concurrency, retrying on conflicts,
+// and reads are served from a copy that Run keeps up-to-date.
+type ConfigMap struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+
+	mu        sync.RWMutex
+	endpoints []*endpoint.Endpoint
+	watchers  Watchers
+}
+
+var _ Store = &ConfigMap{}
+
+// Load reads the Endpoints from the ConfigMap, if it exists.
+func (s *ConfigMap) Load(ctx context.Context) error {
+	cm, err := s.Client.CoreV1().ConfigMaps(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return s.set(nil)
+	} else if err != nil {
+		return err
+	}
+
+	return s.set(cm)
+}
+
+// Run watches the ConfigMap for changes made by other replicas
+// until ctx is done.
+func (s *ConfigMap) Run(ctx context.Context) error {
+	for {
+		if err := s.watch(ctx); err != nil && ctx.Err() == nil {
+			// Watches are expected to end every so often,
+			// so only back off from those that fail.
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *ConfigMap) watch(ctx context.Context) error {
+	w, err := s.Client.CoreV1().ConfigMaps(s.Namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", s.Name).String(),
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	// Catch up on changes made before the watch started.
+	if err := s.Load(ctx); err != nil {
+		return err
+	}
+
+	for ev := range w.ResultChan() {
+		switch ev.Type {
+		case watch.Added, watch.Modified:
+			if cm, ok := ev.Object.(*corev1.ConfigMap); ok && cm.Name == s.Name {
+				if err := s.set(cm); err != nil {
+					return err
+				}
+			}
+		case watch.Deleted:
+			if cm, ok := ev.Object.(*corev1.ConfigMap); ok && cm.Name == s.Name {
+				if err := s.set(nil); err != nil {
+					return err
+				}
+			}
+		case watch.Error:
+			return apierrors.FromObject(ev.Object)
+		}
+	}
+
+	return nil
+}
+
+// Get implements Store.
+func (s *ConfigMap) Get(_ context.Context, name string) ([]*endpoint.Endpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name = normalizeName(name)
+
+	eps := []*endpoint.Endpoint{}
+	for _, ep := range s.endpoints {
+		if strings.EqualFold(ep.DNSName, name) {
+			eps = append(eps, ep)
+		}
+	}
+
+	return eps, nil
+}
+
+// List implements Store.
+func (s *ConfigMap) List(_ context.Context) ([]*endpoint.Endpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.endpoints), nil
+}
+
+// Apply implements Store. Changes are merged into the latest Endpoints
+// in the ConfigMap, creating it if need be, and retried if another
+// replica changes it in the meantime.
+func (s *ConfigMap) Apply(ctx context.Context, changes *Changes) error {
+	configMaps := s.Client.CoreV1().ConfigMaps(s.Namespace)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, s.Name, metav1.GetOptions{})
+		create := apierrors.IsNotFound(err)
+		if create {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      s.Name,
+					Namespace: s.Namespace,
+				},
+			}
+		} else if err != nil {
+			return err
+		}
+
+		current, err := decodeConfigMap(cm)
+		if err != nil {
+			return err
+		}
+
+		endpoints, _ := Merge(current, changes)
+
+		b, err := json.Marshal(endpoints)
+		if err != nil {
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[ConfigMapKey] = string(b)
+
+		if create {
+			cm, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Another replica created it first.
+				return apierrors.NewConflict(corev1.Resource("configmaps"), s.Name, err)
+			}
+		} else {
+			cm, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+
+		return s.set(cm)
+	})
+}
+
+// Watch implements Store.
+func (s *ConfigMap) Watch(ctx context.Context) (<-chan struct{}, error) {
+	return s.watchers.Watch(ctx), nil
+}
+
+// set replaces the Endpoints with those in cm and notifies watchers.
+func (s *ConfigMap) set(cm *corev1.ConfigMap) error {
+	endpoints, err := decodeConfigMap(cm)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.endpoints = endpoints
+	s.mu.Unlock()
+
+	s.watchers.Notify()
+
+	return nil
+}
+
+func decodeConfigMap(cm *corev1.ConfigMap) ([]*endpoint.Endpoint, error) {
+	endpoints := []*endpoint.Endpoint{}
+
+	if cm == nil || cm.Data[ConfigMapKey] == "" {
+		return endpoints, nil
+	}
+
+	if err := json.Unmarshal([]byte(cm.Data[ConfigMapKey]), &endpoints); err != nil {
+		return nil, fmt.Errorf("decode configmap %s/%s: %w", cm.Namespace, cm.Name, err)
+	}
+
+	return endpoints, nil
+}
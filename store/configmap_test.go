@@ -0,0 +1,165 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func newConfigMap(client *fake.Clientset) *store.ConfigMap {
+	return &store.ConfigMap{
+		Client:    client,
+		Namespace: "external-dns",
+		Name:      "dnsserver",
+	}
+}
+
+func TestConfigMap(t *testing.T) {
+	client := fake.NewClientset()
+
+	testStore(t, newConfigMap(client))
+
+	cm, err := client.CoreV1().ConfigMaps("external-dns").Get(context.TODO(), "dnsserver", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if cm.Data[store.ConfigMapKey] == "" {
+		t.Fatal("expected endpoints to be saved to the configmap")
+	}
+}
+
+func TestConfigMapReplicas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	var (
+		client = fake.NewClientset()
+		a      = newConfigMap(client)
+		b      = newConfigMap(client)
+	)
+
+	if err := a.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	watch, err := b.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go b.Run(ctx) //nolint:errcheck
+
+	// b catches up on what a applied before it started.
+	waitFor(t, watch, func() bool {
+		eps, _ := b.Get(ctx, "www.frantj.cc")
+		return len(eps) == 1
+	})
+
+	if err := a.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// b sees what a applies while it watches.
+	waitFor(t, watch, func() bool {
+		eps, _ := b.Get(ctx, "app.frantj.cc")
+		return len(eps) == 1
+	})
+
+	// a picks up what b applies on its next write.
+	if err := b.Apply(ctx, &store.Changes{
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("api.frantj.cc", endpoint.RecordTypeA, "10.0.0.7"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if eps, err := a.List(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(eps) != 2 {
+		t.Fatalf("expected a to have b's deletion, got %v", eps)
+	}
+}
+
+func TestConfigMapConflict(t *testing.T) {
+	var (
+		ctx       = context.TODO()
+		client    = fake.NewClientset()
+		s         = newConfigMap(client)
+		conflicts = 0
+	)
+
+	if err := s.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	client.PrependReactor("update", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts < 2 {
+			conflicts++
+			return true, nil, apierrors.NewConflict(corev1.Resource("configmaps"), "dnsserver", nil)
+		}
+
+		return false, nil, nil
+	})
+
+	if err := s.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if conflicts != 2 {
+		t.Fatalf("expected 2 conflicts, got %d", conflicts)
+	}
+
+	if eps, err := s.List(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(eps) != 2 {
+		t.Fatalf("expected 2 endpoints, got %v", eps)
+	}
+}
+
+// waitFor waits for watch to signal until ok is true.
+func waitFor(t *testing.T, watch <-chan struct{}, ok func() bool) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+
+	for !ok() {
+		select {
+		case <-watch:
+		case <-timeout:
+			t.Fatal("timed out waiting for changes")
+		}
+	}
+}
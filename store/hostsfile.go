@@ -0,0 +1,281 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/hosts"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// HostsFile is a Store that keeps Endpoints in memory and, optionally,
+// writes the addresses of its A and AAAA records to a hosts file and
+// persists all of them to a JSON state file.
+type HostsFile struct {
+	// File, if set, is the hosts file that Hosts is written to.
+	File string
+
+	// Hosts is the content of File. Any hosts that it has before
+	// the first Apply, e.g. from an initial hosts file, are kept.
+	Hosts *hosts.Hosts
+
+	// StateFile, if set, is where Endpoints are persisted so
+	// that they can be reloaded by Load after a restart.
+	StateFile string
+
+	mu        sync.RWMutex
+	endpoints []*endpoint.Endpoint
+	watchers  Watchers
+}
+
+var _ Store = &HostsFile{}
+
+// Load reads the Endpoints persisted at s.StateFile, if any, and adds
+// their addresses to s.Hosts so that they can be served right away.
+func (s *HostsFile) Load() error {
+	if s.StateFile == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	endpoints := []*endpoint.Endpoint{}
+	if err := json.NewDecoder(f).Decode(&endpoints); err != nil {
+		return fmt.Errorf("decode state %s: %w", s.StateFile, err)
+	}
+
+	if s.Hosts == nil {
+		s.Hosts = &hosts.Hosts{}
+	}
+
+	for _, ep := range endpoints {
+		if IsAddressRecordType(ep.RecordType) {
+			for _, target := range ep.Targets {
+				ip, err := ParseAddress(ep.RecordType, target)
+				if err != nil {
+					return fmt.Errorf("decode state %s: %w", s.StateFile, err)
+				}
+
+				s.Hosts.Add(hosts.Host{
+					IP:        ip,
+					Hostnames: []string{ep.DNSName},
+				})
+			}
+		}
+	}
+
+	s.endpoints = endpoints
+
+	return nil
+}
+
+// Get implements Store.
+func (s *HostsFile) Get(_ context.Context, name string) ([]*endpoint.Endpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name = normalizeName(name)
+
+	eps := []*endpoint.Endpoint{}
+	for _, ep := range s.endpoints {
+		if strings.EqualFold(ep.DNSName, name) {
+			eps = append(eps, ep)
+		}
+	}
+
+	return eps, nil
+}
+
+// List implements Store.
+func (s *HostsFile) List(_ context.Context) ([]*endpoint.Endpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.endpoints), nil
+}
+
+// Apply implements Store. Changes are applied to a copy of s.Hosts that
+// is only swapped in once both the hosts file and the state have been
+// written, so that a failure leaves everything as it was.
+func (s *HostsFile) Apply(_ context.Context, changes *Changes) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Hosts == nil {
+		s.Hosts = &hosts.Hosts{}
+	}
+
+	var (
+		endpoints, removed = Merge(s.endpoints, changes)
+		h                  = &hosts.Hosts{Hosts: slices.Clone(s.Hosts.Hosts)}
+		modified           bool
+		remaining          map[address]struct{}
+	)
+
+	if len(removed) > 0 {
+		remaining = addresses(endpoints)
+	}
+
+	for _, ep := range removed {
+		if IsAddressRecordType(ep.RecordType) {
+			for _, target := range ep.Targets {
+				ip, err := ParseAddress(ep.RecordType, target)
+				if err != nil {
+					return err
+				}
+
+				// Another set identifier may still have the address.
+				if _, ok := remaining[newAddress(ep.DNSName, ep.RecordType, ip)]; ok {
+					continue
+				}
+
+				modified = h.Remove(hosts.Host{
+					IP:        ip,
+					Hostnames: []string{ep.DNSName},
+				}) || modified
+			}
+		}
+	}
+
+	if changes != nil {
+		for _, ep := range changes.Put {
+			if IsAddressRecordType(ep.RecordType) {
+				for _, target := range ep.Targets {
+					ip, err := ParseAddress(ep.RecordType, target)
+					if err != nil {
+						return err
+					}
+
+					modified = h.Add(hosts.Host{
+						IP:        ip,
+						Hostnames: []string{ep.DNSName},
+					}) || modified
+				}
+			}
+		}
+	}
+
+	modified = modified && s.File != ""
+
+	if modified {
+		if err := WriteFileAtomic(s.File, h.Encode); err != nil {
+			return fmt.Errorf("write hosts file %s: %w", s.File, err)
+		}
+	}
+
+	if err := s.save(endpoints); err != nil {
+		if modified {
+			if rerr := WriteFileAtomic(s.File, s.Hosts.Encode); rerr != nil {
+				return errors.Join(err, fmt.Errorf("restore hosts file %s: %w", s.File, rerr))
+			}
+		}
+
+		return err
+	}
+
+	s.Hosts.Hosts = h.Hosts
+	s.endpoints = endpoints
+	s.watchers.Notify()
+
+	return nil
+}
+
+// Watch implements Store.
+func (s *HostsFile) Watch(ctx context.Context) (<-chan struct{}, error) {
+	return s.watchers.Watch(ctx), nil
+}
+
+// save persists endpoints to s.StateFile. The caller must hold s's lock.
+func (s *HostsFile) save(endpoints []*endpoint.Endpoint) error {
+	if s.StateFile == "" {
+		return nil
+	}
+
+	return WriteFileAtomic(s.StateFile, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(endpoints)
+	})
+}
+
+// address is a target of the A or AAAA records at a name.
+type address struct {
+	name, recordType, ip string
+}
+
+func newAddress(name, recordType string, ip net.IP) address {
+	return address{strings.ToLower(name), recordType, ip.String()}
+}
+
+// addresses indexes the targets of the A and AAAA records in endpoints.
+func addresses(endpoints []*endpoint.Endpoint) map[address]struct{} {
+	index := map[address]struct{}{}
+
+	for _, ep := range endpoints {
+		if !IsAddressRecordType(ep.RecordType) {
+			continue
+		}
+
+		for _, target := range ep.Targets {
+			if ip, err := ParseAddress(ep.RecordType, target); err == nil {
+				index[newAddress(ep.DNSName, ep.RecordType, ip)] = struct{}{}
+			}
+		}
+	}
+
+	return index
+}
+
+// WriteFileAtomic replaces the file at name with what write writes to it
+// such that name is either entirely the old or entirely the new content,
+// even if the process crashes midway through.
+func WriteFileAtomic(name string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := write(f); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
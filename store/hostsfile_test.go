@@ -0,0 +1,165 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/hosts"
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func newHostsFile(t *testing.T) *store.HostsFile {
+	t.Helper()
+
+	return &store.HostsFile{
+		File:  filepath.Join(t.TempDir(), "hosts"),
+		Hosts: &hosts.Hosts{},
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestHostsFile(t *testing.T) {
+	s := newHostsFile(t)
+
+	testStore(t, s)
+
+	expected := "fd00::5 www.frantj.cc\n10.0.0.7 www.frantj.cc.example.com\n10.0.0.8 www.frantj.cc\n"
+	if actual := readFile(t, s.File); actual != expected {
+		t.Fatalf("expected hosts file %q, got %q", expected, actual)
+	}
+}
+
+func TestHostsFileStateFile(t *testing.T) {
+	var (
+		ctx       = context.TODO()
+		stateFile = filepath.Join(t.TempDir(), "endpoints.json")
+		s         = newHostsFile(t)
+	)
+
+	s.StateFile = stateFile
+
+	if err := s.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("www.frantj.cc", endpoint.RecordTypeA, 60, "10.0.0.5").
+				WithLabel(endpoint.OwnerLabelKey, "default"),
+			endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeCNAME, "www.frantj.cc"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newHostsFile(t)
+	r.StateFile = stateFile
+
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := r.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+
+	if records[0].RecordTTL != 60 || !records[0].IsOwnedBy("default") {
+		t.Fatalf("expected TTL and labels to be persisted, got %v %v", records[0], records[0].Labels)
+	}
+
+	b := new(bytes.Buffer)
+	if err := r.Hosts.Encode(b); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "10.0.0.5 www.frantj.cc\n"
+	if b.String() != expected {
+		t.Fatalf("expected hosts %q, got %q", expected, b.String())
+	}
+
+	if err := newHostsFile(t).Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHostsFileApplyAtomic(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		s   = newHostsFile(t)
+	)
+
+	if err := s.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := readFile(t, s.File)
+
+	if err := s.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("a.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+			endpoint.NewEndpoint("b.frantj.cc", endpoint.RecordTypeA, "fd00::7"),
+		},
+	}); err == nil {
+		t.Fatal("expected error for invalid address")
+	}
+
+	if actual := readFile(t, s.File); actual != expected {
+		t.Fatalf("expected hosts file %q, got %q", expected, actual)
+	}
+
+	// Writing the state fails, so the hosts file must be restored.
+	s.StateFile = filepath.Join(t.TempDir(), "missing", "endpoints.json")
+
+	if err := s.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("a.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+		},
+	}); err == nil {
+		t.Fatal("expected error for unwritable state file")
+	}
+
+	if actual := readFile(t, s.File); actual != expected {
+		t.Fatalf("expected hosts file %q, got %q", expected, actual)
+	}
+
+	if eps, err := s.Get(ctx, "a.frantj.cc"); err != nil {
+		t.Fatal(err)
+	} else if len(eps) != 0 {
+		t.Fatalf("expected a.frantj.cc not to exist, got %v", eps)
+	}
+
+	// Writing the hosts file fails.
+	s.StateFile = ""
+	s.File = filepath.Join(t.TempDir(), "missing", "hosts")
+
+	if err := s.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("a.frantj.cc", endpoint.RecordTypeA, "10.0.0.6"),
+		},
+	}); err == nil {
+		t.Fatal("expected error for unwritable hosts file")
+	}
+
+	if eps, err := s.Get(ctx, "a.frantj.cc"); err != nil {
+		t.Fatal(err)
+	} else if len(eps) != 0 {
+		t.Fatalf("expected a.frantj.cc not to exist, got %v", eps)
+	}
+}
@@ -0,0 +1,492 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/internal/logutil"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// ErrNoLeader is returned from Raft.Apply when the
+// cluster does not elect a leader in time.
+var ErrNoLeader = errors.New("raft cluster has no leader")
+
+// RaftConfig configures a node of a Raft cluster.
+type RaftConfig struct {
+	// ID identifies the node within the cluster.
+	ID string
+	// Addr is the address to listen on for the other nodes.
+	Addr string
+	// Peers are the addresses that the other nodes can reach every node
+	// in the cluster at, including this one, keyed by their IDs. The
+	// cluster is bootstrapped with them unless Dir already has state.
+	Peers map[string]string
+	// Secret is shared by every node in the cluster. Connections from
+	// anything that does not know it, whether Raft's own RPCs or changes
+	// forwarded to the leader, are dropped. It is required. Traffic is
+	// not encrypted, so nodes should still talk over a private network.
+	Secret string
+	// Dir is where the log and snapshots are persisted.
+	// If empty, they are kept in memory.
+	Dir string
+	// Timeout bounds how long Apply waits for a leader and
+	// for its changes to be committed. Defaults to 10s.
+	Timeout time.Duration
+}
+
+// Raft is a Store whose Endpoints are replicated across a cluster of
+// nodes by Raft. Writes go through the leader, to which followers forward
+// them, and reads are served from the changes that this node has applied,
+// which are all committed.
+type Raft struct {
+	id        raft.ServerID
+	raft      *raft.Raft
+	transport *raft.NetworkTransport
+	mux       *raftMux
+	closers   []io.Closer
+	timeout   time.Duration
+
+	mu        sync.RWMutex
+	endpoints []*endpoint.Endpoint
+	index     uint64
+	watchers  Watchers
+}
+
+var _ Store = &Raft{}
+
+// OpenRaft starts a node of the Raft cluster described by cfg.
+func OpenRaft(ctx context.Context, cfg *RaftConfig) (*Raft, error) {
+	advertise, ok := cfg.Peers[cfg.ID]
+	if !ok {
+		return nil, fmt.Errorf("raft peers do not include %s", cfg.ID)
+	} else if cfg.Secret == "" {
+		return nil, errors.New("raft secret is required")
+	}
+
+	var (
+		log   = logutil.SloggerFrom(ctx)
+		level = hclog.Info
+	)
+
+	if log.Enabled(ctx, slog.LevelDebug) {
+		level = hclog.Debug
+	}
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:        "raft",
+		Level:       level,
+		Output:      slog.NewLogLogger(log.Handler(), slog.LevelInfo).Writer(),
+		DisableTime: true,
+	})
+
+	s := &Raft{
+		id:        raft.ServerID(cfg.ID),
+		endpoints: []*endpoint.Endpoint{},
+		timeout:   cfg.Timeout,
+	}
+
+	if s.timeout == 0 {
+		s.timeout = time.Second * 10
+	}
+
+	var (
+		logs   raft.LogStore
+		stable raft.StableStore
+		snaps  raft.SnapshotStore
+	)
+
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			return nil, err
+		}
+
+		b, err := raftboltdb.NewBoltStore(filepath.Join(cfg.Dir, "raft.db"))
+		if err != nil {
+			return nil, fmt.Errorf("open raft log: %w", err)
+		}
+		s.closers = append(s.closers, b)
+
+		if snaps, err = raft.NewFileSnapshotStoreWithLogger(cfg.Dir, 2, logger); err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("open raft snapshots: %w", err)
+		}
+
+		logs, stable = b, b
+	} else {
+		m := raft.NewInmemStore()
+		logs, stable, snaps = m, m, raft.NewInmemSnapshotStore()
+	}
+
+	mux, err := listenRaftMux(cfg.Addr, advertise, raftToken(cfg.Secret))
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	s.mux = mux
+
+	s.transport = raft.NewNetworkTransportWithLogger(mux.listener(raftConnRaft), 3, s.timeout, logger)
+
+	config := raft.DefaultConfig()
+	config.LocalID = s.id
+	config.Logger = logger
+
+	hasState, err := raft.HasExistingState(logs, stable, snaps)
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+
+	if s.raft, err = raft.NewRaft(config, &raftFSM{s}, logs, stable, snaps, s.transport); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+
+	if !hasState {
+		configuration := raft.Configuration{}
+		for id, addr := range cfg.Peers {
+			configuration.Servers = append(configuration.Servers, raft.Server{
+				ID:      raft.ServerID(id),
+				Address: raft.ServerAddress(addr),
+			})
+		}
+
+		if err := s.raft.BootstrapCluster(configuration).Error(); err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+			_ = s.Close()
+			return nil, fmt.Errorf("bootstrap raft cluster: %w", err)
+		}
+	}
+
+	go s.serveForwards(mux.listener(raftConnForward))
+
+	return s, nil
+}
+
+// Close stops the node.
+func (s *Raft) Close() error {
+	errs := []error{}
+
+	if s.raft != nil {
+		errs = append(errs, s.raft.Shutdown().Error())
+	}
+
+	if s.transport != nil {
+		errs = append(errs, s.transport.Close())
+	}
+
+	if s.mux != nil {
+		if err := s.mux.Close(); !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, c := range s.closers {
+		errs = append(errs, c.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Leader returns the ID of the cluster's leader, if known.
+func (s *Raft) Leader() string {
+	_, id := s.raft.LeaderWithID()
+	return string(id)
+}
+
+// Get implements Store.
+func (s *Raft) Get(_ context.Context, name string) ([]*endpoint.Endpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name = normalizeName(name)
+
+	eps := []*endpoint.Endpoint{}
+	for _, ep := range s.endpoints {
+		if strings.EqualFold(ep.DNSName, name) {
+			eps = append(eps, ep)
+		}
+	}
+
+	return eps, nil
+}
+
+// List implements Store.
+func (s *Raft) List(_ context.Context) ([]*endpoint.Endpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.endpoints), nil
+}
+
+// Apply implements Store. Followers forward changes to the leader.
+// Either way, Apply returns once this node has applied them.
+func (s *Raft) Apply(ctx context.Context, changes *Changes) error {
+	if changes == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	for {
+		// Changes are idempotent, so they can be retried even if they
+		// were committed but the response was lost.
+		index, err := s.apply(ctx, b)
+		if err == nil {
+			return s.waitForIndex(ctx, index)
+		}
+
+		// The leader may have changed, so try again
+		// until the cluster settles or time runs out.
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+}
+
+// apply commits b to the log through the leader, returning its index.
+func (s *Raft) apply(ctx context.Context, b []byte) (uint64, error) {
+	addr, id, err := s.waitForLeader(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if id == s.id {
+		return s.applyLog(b)
+	}
+
+	index, err := s.forward(ctx, string(addr), b)
+	if err != nil {
+		return 0, fmt.Errorf("forward to raft leader %s: %w", id, err)
+	}
+
+	return index, nil
+}
+
+// Watch implements Store.
+func (s *Raft) Watch(ctx context.Context) (<-chan struct{}, error) {
+	return s.watchers.Watch(ctx), nil
+}
+
+func (s *Raft) waitForLeader(ctx context.Context) (raft.ServerAddress, raft.ServerID, error) {
+	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+
+	for {
+		if addr, id := s.raft.LeaderWithID(); id != "" {
+			return addr, id, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", "", ErrNoLeader
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Raft) waitForIndex(ctx context.Context, index uint64) error {
+	ticker := time.NewTicker(time.Millisecond * 10)
+	defer ticker.Stop()
+
+	for {
+		s.mu.RLock()
+		applied := s.index
+		s.mu.RUnlock()
+
+		if applied >= index {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for raft index %d: %w", index, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyLog commits b to the log, returning its index.
+// Only the leader can do so.
+func (s *Raft) applyLog(b []byte) (uint64, error) {
+	f := s.raft.Apply(b, s.timeout)
+	if err := f.Error(); err != nil {
+		return 0, err
+	}
+
+	if err, ok := f.Response().(error); ok {
+		return 0, err
+	}
+
+	return f.Index(), nil
+}
+
+// forwardResponse is the leader's response to changes forwarded to it.
+type forwardResponse struct {
+	Index uint64 `json:"index,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func (s *Raft) forward(ctx context.Context, addr string, b []byte) (uint64, error) {
+	deadline, _ := ctx.Deadline()
+
+	conn, err := dialRaftMux(addr, raftConnForward, s.mux.token, time.Until(deadline))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	if _, err := conn.Write(b); err != nil {
+		return 0, err
+	}
+
+	res := &forwardResponse{}
+	if err := json.NewDecoder(conn).Decode(res); err != nil {
+		return 0, err
+	} else if res.Error != "" {
+		return 0, errors.New(res.Error)
+	}
+
+	return res.Index, nil
+}
+
+func (s *Raft) serveForwards(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			_ = conn.SetDeadline(time.Now().Add(s.timeout))
+
+			var (
+				res = &forwardResponse{}
+				b   json.RawMessage
+			)
+
+			// Changes are only forwarded once, so a node that
+			// is no longer the leader rejects them.
+			if err := json.NewDecoder(conn).Decode(&b); err != nil {
+				res.Error = err.Error()
+			} else if res.Index, err = s.applyLog(b); err != nil {
+				res.Error = err.Error()
+			}
+
+			_ = json.NewEncoder(conn).Encode(res)
+		}()
+	}
+}
+
+// set replaces the Endpoints and notifies watchers.
+func (s *Raft) set(endpoints []*endpoint.Endpoint, index uint64) {
+	s.mu.Lock()
+	s.endpoints = endpoints
+	s.index = index
+	s.mu.Unlock()
+
+	s.watchers.Notify()
+}
+
+// raftFSM applies committed Changes to a Raft's Endpoints.
+type raftFSM struct {
+	s *Raft
+}
+
+var _ raft.FSM = &raftFSM{}
+
+// raftSnapshot is the state of a raftFSM as of the log entry at Index.
+type raftSnapshot struct {
+	Index     uint64               `json:"index"`
+	Endpoints []*endpoint.Endpoint `json:"endpoints"`
+}
+
+// Apply implements raft.FSM.
+func (f *raftFSM) Apply(l *raft.Log) any {
+	changes := &Changes{}
+	if err := json.Unmarshal(l.Data, changes); err != nil {
+		// Still advance the index so that nothing waits on this entry.
+		f.s.set(f.endpoints(), l.Index)
+		return fmt.Errorf("decode raft log %d: %w", l.Index, err)
+	}
+
+	endpoints, _ := Merge(f.endpoints(), changes)
+	f.s.set(endpoints, l.Index)
+
+	return nil
+}
+
+// Snapshot implements raft.FSM.
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.s.mu.RLock()
+	defer f.s.mu.RUnlock()
+
+	return &raftSnapshot{
+		Index:     f.s.index,
+		Endpoints: slices.Clone(f.s.endpoints),
+	}, nil
+}
+
+// Restore implements raft.FSM.
+func (f *raftFSM) Restore(r io.ReadCloser) error {
+	defer r.Close()
+
+	snapshot := &raftSnapshot{}
+	if err := json.NewDecoder(r).Decode(snapshot); err != nil {
+		return fmt.Errorf("decode raft snapshot: %w", err)
+	}
+
+	if snapshot.Endpoints == nil {
+		snapshot.Endpoints = []*endpoint.Endpoint{}
+	}
+
+	f.s.set(snapshot.Endpoints, snapshot.Index)
+
+	return nil
+}
+
+func (f *raftFSM) endpoints() []*endpoint.Endpoint {
+	f.s.mu.RLock()
+	defer f.s.mu.RUnlock()
+
+	return f.s.endpoints
+}
+
+// Persist implements raft.FSMSnapshot.
+func (s *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s); err != nil {
+		return errors.Join(err, sink.Cancel())
+	}
+
+	return sink.Close()
+}
+
+// Release implements raft.FSMSnapshot.
+func (s *raftSnapshot) Release() {}
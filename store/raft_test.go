@@ -0,0 +1,218 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// openRaftCluster starts n nodes of a Raft cluster on loopback.
+func openRaftCluster(t *testing.T, n int) []*store.Raft {
+	t.Helper()
+
+	peers := map[string]string{}
+	for i := range n {
+		// Find a free port for each node.
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		peers[fmt.Sprint(i)] = l.Addr().String()
+
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nodes := make([]*store.Raft, n)
+	for i := range n {
+		id := fmt.Sprint(i)
+
+		s, err := store.OpenRaft(context.TODO(), &store.RaftConfig{
+			ID:      id,
+			Addr:    peers[id],
+			Peers:   peers,
+			Secret:  "hunter2",
+			Dir:     t.TempDir(),
+			Timeout: 5 * time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = s.Close() })
+
+		nodes[i] = s
+	}
+
+	return nodes
+}
+
+// waitForLeader returns the index of the node that every node in nodes
+// agrees is the leader.
+func waitForLeader(t *testing.T, nodes []*store.Raft) int {
+	t.Helper()
+
+	timeout := time.After(10 * time.Second)
+
+	for {
+		leader := nodes[0].Leader()
+		if leader != "" && !slices.ContainsFunc(nodes, func(s *store.Raft) bool {
+			return s.Leader() != leader
+		}) {
+			i, err := strconv.Atoi(leader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return i
+		}
+
+		select {
+		case <-timeout:
+			t.Fatal("timed out waiting for a leader")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestRaft(t *testing.T) {
+	var (
+		ctx      = context.TODO()
+		nodes    = openRaftCluster(t, 3)
+		leader   = waitForLeader(t, nodes)
+		follower = nodes[(leader+1)%len(nodes)]
+	)
+
+	// Writes to a follower are forwarded to the leader.
+	testStore(t, follower)
+
+	// Every node serves the committed changes.
+	for _, s := range nodes {
+		watch, err := s.Watch(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		waitFor(t, watch, func() bool {
+			eps, _ := s.List(ctx)
+			return len(eps) == 4
+		})
+	}
+
+	if err := nodes[leader].Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The remaining nodes elect a new leader and keep taking writes.
+	if err := follower.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("api.frantj.cc", endpoint.RecordTypeA, "10.0.0.9"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if eps, err := follower.Get(ctx, "api.frantj.cc"); err != nil {
+		t.Fatal(err)
+	} else if len(eps) != 1 {
+		t.Fatalf("expected api.frantj.cc to exist, got %v", eps)
+	}
+}
+
+func TestRaftNoQuorum(t *testing.T) {
+	var (
+		ctx    = context.TODO()
+		nodes  = openRaftCluster(t, 3)
+		leader = waitForLeader(t, nodes)
+	)
+
+	for i, s := range nodes {
+		if i != leader {
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Without a quorum, the old leader steps down and writes are rejected.
+	if err := nodes[leader].Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+	}); err == nil {
+		t.Fatal("expected error without a quorum")
+	}
+
+	if eps, err := nodes[leader].List(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(eps) != 0 {
+		t.Fatalf("expected no endpoints, got %v", eps)
+	}
+}
+
+func TestRaftSecret(t *testing.T) {
+	ctx := context.TODO()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := l.Addr().String()
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &store.RaftConfig{
+		ID:      "0",
+		Addr:    addr,
+		Peers:   map[string]string{"0": addr},
+		Timeout: 5 * time.Second,
+	}
+
+	if _, err := store.OpenRaft(ctx, cfg); err == nil {
+		t.Fatal("expected error without a secret")
+	}
+
+	cfg.Secret = "hunter2"
+
+	s, err := store.OpenRaft(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	waitForLeader(t, []*store.Raft{s})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Changes forwarded, which connections of type 2 are, without
+	// the secret's token, which would follow the type, are dropped.
+	if _, err := conn.Write(append(append([]byte{2}, make([]byte, 32)...), []byte(`{"put":[{"dnsName":"www.frantj.cc","recordType":"A","targets":["10.0.0.5"]}]}`)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := conn.Read(make([]byte, 1)); n != 0 || err == nil {
+		t.Fatalf("expected the connection to be dropped, got %d bytes and %v", n, err)
+	}
+
+	if eps, err := s.List(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(eps) != 0 {
+		t.Fatalf("expected no endpoints, got %v", eps)
+	}
+}
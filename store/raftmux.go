@@ -0,0 +1,169 @@
+package store
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"io"
+	"net"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Connections to a raftMux start with one of these bytes
+// to say which of its listeners they are for.
+const (
+	raftConnRaft byte = iota + 1
+	raftConnForward
+)
+
+// raftMux shares one address between Raft's own
+// RPCs and the changes that followers forward.
+// Connections of either type must follow their first
+// byte with the token of the cluster's secret.
+type raftMux struct {
+	net.Listener
+	advertise raftAddr
+	token     []byte
+	conns     map[byte]chan net.Conn
+	done      chan struct{}
+}
+
+// raftToken returns the token that nodes present to each other to
+// prove that they know secret without sending it as-is.
+func raftToken(secret string) []byte {
+	token := sha256.Sum256([]byte("raft:" + secret))
+	return token[:]
+}
+
+func listenRaftMux(addr, advertise string, token []byte) (*raftMux, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &raftMux{
+		Listener:  l,
+		advertise: raftAddr(advertise),
+		token:     token,
+		conns: map[byte]chan net.Conn{
+			raftConnRaft:    make(chan net.Conn),
+			raftConnForward: make(chan net.Conn),
+		},
+		done: make(chan struct{}),
+	}
+
+	go m.serve()
+
+	return m, nil
+}
+
+func dialRaftMux(addr string, typ byte, token []byte, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(append([]byte{typ}, token...)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (m *raftMux) serve() {
+	defer close(m.done)
+
+	for {
+		conn, err := m.Listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go m.route(conn)
+	}
+}
+
+func (m *raftMux) route(conn net.Conn) {
+	header := make([]byte, 1+len(m.token))
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+	if _, err := io.ReadFull(conn, header); err != nil {
+		conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	// Connections from anything but the other nodes,
+	// which know the secret, are dropped.
+	if subtle.ConstantTimeCompare(header[1:], m.token) != 1 {
+		conn.Close()
+		return
+	}
+
+	c, ok := m.conns[header[0]]
+	if !ok {
+		conn.Close()
+		return
+	}
+
+	select {
+	case c <- conn:
+	case <-m.done:
+		conn.Close()
+	}
+}
+
+func (m *raftMux) listener(typ byte) *raftMuxListener {
+	return &raftMuxListener{m, typ}
+}
+
+// raftMuxListener accepts a raftMux's connections of one type. It is
+// also the raft.StreamLayer that dials other nodes' raftMuxes.
+type raftMuxListener struct {
+	mux *raftMux
+	typ byte
+}
+
+var _ raft.StreamLayer = &raftMuxListener{}
+
+// Accept implements net.Listener.
+func (l *raftMuxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.mux.conns[l.typ]:
+		return conn, nil
+	case <-l.mux.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener. Connections of other
+// types are no longer accepted either.
+func (l *raftMuxListener) Close() error {
+	return l.mux.Close()
+}
+
+// Addr implements net.Listener. It returns the address
+// that the other nodes can reach this one at.
+func (l *raftMuxListener) Addr() net.Addr {
+	return l.mux.advertise
+}
+
+// Dial implements raft.StreamLayer.
+func (l *raftMuxListener) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return dialRaftMux(string(address), l.typ, l.mux.token, timeout)
+}
+
+// raftAddr is a net.Addr for a host:port that may not be resolvable.
+type raftAddr string
+
+// Network implements net.Addr.
+func (a raftAddr) Network() string {
+	return "tcp"
+}
+
+// String implements net.Addr.
+func (a raftAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,168 @@
+// Package store defines where the Endpoints that external-dns manages are
+// kept, along with implementations backed by a hosts file and by bbolt.
+package store
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+
+	xslices "github.com/frantjc/x/slices"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// Store stores Endpoints, each identified by its DNSName,
+// RecordType and SetIdentifier as returned by Key.
+type Store interface {
+	// Get returns the Endpoints whose DNSName is name.
+	Get(ctx context.Context, name string) ([]*endpoint.Endpoint, error)
+	// List returns all Endpoints.
+	List(ctx context.Context) ([]*endpoint.Endpoint, error)
+	// Apply applies changes such that either all or none of them are.
+	Apply(ctx context.Context, changes *Changes) error
+	// Watch returns a channel that receives whenever the Endpoints
+	// change until ctx is done, at which point it is closed.
+	Watch(ctx context.Context) (<-chan struct{}, error)
+}
+
+// Changes are changes to the Endpoints of a Store.
+type Changes struct {
+	// Delete are Endpoints to delete. Only their keys matter.
+	Delete []*endpoint.Endpoint
+	// Put are Endpoints to create or, if one with
+	// the same key exists, to replace it with.
+	Put []*endpoint.Endpoint
+}
+
+// Key returns the identity of ep within a Store, which
+// is its DNSName, RecordType and SetIdentifier.
+func Key(ep *endpoint.Endpoint) endpoint.EndpointKey {
+	key := ep.Key()
+	key.DNSName = normalizeName(key.DNSName)
+	return key
+}
+
+// Merge returns endpoints with changes applied, deletions first, along
+// with the Endpoints from endpoints that were deleted or replaced.
+func Merge(endpoints []*endpoint.Endpoint, changes *Changes) ([]*endpoint.Endpoint, []*endpoint.Endpoint) {
+	var (
+		merged  = slices.Clone(endpoints)
+		removed = []*endpoint.Endpoint{}
+		index   = make(map[endpoint.EndpointKey]int, len(merged))
+	)
+
+	if changes == nil {
+		return merged, removed
+	}
+
+	for i, ep := range merged {
+		index[Key(ep)] = i
+	}
+
+	for _, del := range changes.Delete {
+		if i, ok := index[Key(del)]; ok {
+			removed = append(removed, merged[i])
+			merged[i] = nil
+			delete(index, Key(del))
+		}
+	}
+
+	for _, put := range changes.Put {
+		if i, ok := index[Key(put)]; ok {
+			if merged[i] != nil {
+				removed = append(removed, merged[i])
+			}
+			merged[i] = put
+		} else {
+			index[Key(put)] = len(merged)
+			merged = append(merged, put)
+		}
+	}
+
+	return xslices.Filter(merged, func(ep *endpoint.Endpoint, _ int) bool {
+		return ep != nil
+	}), removed
+}
+
+// IsAddressRecordType reports whether recordType is A or AAAA.
+func IsAddressRecordType(recordType string) bool {
+	return recordType == endpoint.RecordTypeA || recordType == endpoint.RecordTypeAAAA
+}
+
+// ParseAddress parses target as the address of an A or AAAA record,
+// rejecting addresses of the other family, including IPv4-mapped IPv6
+// addresses such as "::ffff:10.0.0.1".
+func ParseAddress(recordType, target string) (net.IP, error) {
+	addr, err := netip.ParseAddr(target)
+	if err != nil || addr.Zone() != "" {
+		return nil, fmt.Errorf("invalid IP: %s", target)
+	}
+
+	switch recordType {
+	case endpoint.RecordTypeA:
+		if !addr.Is4() {
+			return nil, fmt.Errorf("invalid IPv4 address for %s record: %s", recordType, target)
+		}
+	case endpoint.RecordTypeAAAA:
+		if !addr.Is6() || addr.Is4In6() {
+			return nil, fmt.Errorf("invalid IPv6 address for %s record: %s", recordType, target)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported address record type: %s", recordType)
+	}
+
+	return net.IP(addr.AsSlice()), nil
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Watchers notifies the channels returned from Watch of changes,
+// e.g. to implement Store.Watch.
+type Watchers struct {
+	mu    sync.Mutex
+	chans map[chan struct{}]struct{}
+}
+
+// Watch returns a channel that receives whenever Notify is
+// called until ctx is done, at which point it is closed.
+func (w *Watchers) Watch(ctx context.Context) <-chan struct{} {
+	c := make(chan struct{}, 1)
+
+	w.mu.Lock()
+	if w.chans == nil {
+		w.chans = map[chan struct{}]struct{}{}
+	}
+	w.chans[c] = struct{}{}
+	w.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		w.mu.Lock()
+		delete(w.chans, c)
+		close(c)
+		w.mu.Unlock()
+	}()
+
+	return c
+}
+
+// Notify signals every watcher without blocking. Watchers that have yet
+// to receive a previous signal only receive one.
+func (w *Watchers) Notify() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for c := range w.chans {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package store_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/store"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// testStore checks the behavior that every Store must have.
+func testStore(t *testing.T, s store.Store) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	watch, err := s.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Apply(ctx, &store.Changes{
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.6").WithSetIdentifier("green"),
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeAAAA, "fd00::5"),
+			endpoint.NewEndpoint("www.frantj.cc.example.com", endpoint.RecordTypeA, "10.0.0.7"),
+			endpoint.NewEndpointWithTTL("app.frantj.cc", endpoint.RecordTypeCNAME, 60, "www.frantj.cc").
+				WithLabel(endpoint.OwnerLabelKey, "default"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-watch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected to be notified of changes")
+	}
+
+	if eps, err := s.Get(ctx, "WWW.frantj.cc."); err != nil {
+		t.Fatal(err)
+	} else if len(eps) != 3 {
+		t.Fatalf("expected 3 endpoints at www.frantj.cc, got %v", eps)
+	}
+
+	if eps, err := s.Get(ctx, "app.frantj.cc"); err != nil {
+		t.Fatal(err)
+	} else if len(eps) != 1 || eps[0].RecordTTL != 60 || !eps[0].IsOwnedBy("default") {
+		t.Fatalf("expected TTL and labels to be stored, got %v", eps)
+	}
+
+	if err := s.Apply(ctx, &store.Changes{
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+			endpoint.NewEndpoint("missing.frantj.cc", endpoint.RecordTypeA, "10.0.0.5"),
+		},
+		Put: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.8").WithSetIdentifier("green"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	eps, err := s.Get(ctx, "www.frantj.cc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(eps) != 2 || !slices.ContainsFunc(eps, func(ep *endpoint.Endpoint) bool {
+		return ep.SetIdentifier == "green" && slices.Equal(ep.Targets, endpoint.Targets{"10.0.0.8"})
+	}) {
+		t.Fatalf("expected the green A record to be replaced and the other deleted, got %v", eps)
+	}
+
+	if eps, err := s.List(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(eps) != 4 {
+		t.Fatalf("expected 4 endpoints, got %v", eps)
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-watch:
+		for ok {
+			_, ok = <-watch
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected watch to be closed")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var (
+		a     = endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeA, "10.0.0.5")
+		aaaa  = endpoint.NewEndpoint("www.frantj.cc", endpoint.RecordTypeAAAA, "fd00::5")
+		newA  = endpoint.NewEndpoint("WWW.frantj.cc.", endpoint.RecordTypeA, "10.0.0.6")
+		cname = endpoint.NewEndpoint("app.frantj.cc", endpoint.RecordTypeCNAME, "www.frantj.cc")
+	)
+
+	merged, removed := store.Merge([]*endpoint.Endpoint{a, aaaa}, &store.Changes{
+		Delete: []*endpoint.Endpoint{aaaa},
+		Put:    []*endpoint.Endpoint{newA, cname},
+	})
+
+	if !slices.Equal(merged, []*endpoint.Endpoint{newA, cname}) {
+		t.Fatalf("expected merged endpoints, got %v", merged)
+	}
+
+	if !slices.Equal(removed, []*endpoint.Endpoint{aaaa, a}) {
+		t.Fatalf("expected removed endpoints, got %v", removed)
+	}
+}
@@ -0,0 +1,102 @@
+// Package zonefile decodes and encodes RFC 1035 master files,
+// i.e. BIND-style zone files.
+package zonefile
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Zone is the records of a zone file.
+type Zone struct {
+	// Origin is the fully-qualified name that relative
+	// names are relative to, e.g. "frantj.cc.".
+	Origin string
+	// TTL is the TTL of records that do not specify one.
+	// When decoding, every record has its TTL set.
+	TTL uint32
+	// Records are the records in the zone.
+	Records []dns.RR
+}
+
+// Decode parses the zone file read from r, resolving names that are relative
+// to origin unless it has its own $ORIGIN directive. $INCLUDE is not allowed.
+func Decode(r io.Reader, origin string) (*Zone, error) {
+	var (
+		zone = &Zone{
+			Origin:  strings.ToLower(dns.Fqdn(origin)),
+			Records: []dns.RR{},
+		}
+		zp = dns.NewZoneParser(r, zone.Origin, "")
+	)
+
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rr.Header().Name = strings.ToLower(rr.Header().Name)
+		zone.Records = append(zone.Records, rr)
+	}
+
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("parse zone file: %w", err)
+	}
+
+	return zone, nil
+}
+
+// Encode writes z to w as a zone file, with $ORIGIN and $TTL directives
+// and the names of records relative to z.Origin where possible.
+func (z *Zone) Encode(w io.Writer) error {
+	origin := dns.Fqdn(z.Origin)
+
+	if _, err := fmt.Fprintf(w, "$ORIGIN %s\n", origin); err != nil {
+		return err
+	}
+
+	if z.TTL > 0 {
+		if _, err := fmt.Fprintf(w, "$TTL %d\n", z.TTL); err != nil {
+			return err
+		}
+	}
+
+	for _, rr := range z.Records {
+		var (
+			hdr  = rr.Header()
+			ttl  = ""
+			data = strings.TrimPrefix(rr.String(), hdr.String())
+		)
+
+		if hdr.Ttl != z.TTL {
+			ttl = fmt.Sprint(hdr.Ttl)
+		}
+
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			relative(hdr.Name, origin),
+			ttl,
+			dns.ClassToString[hdr.Class],
+			dns.TypeToString[hdr.Rrtype],
+			data,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// relative returns name relative to origin, if it is in it.
+func relative(name, origin string) string {
+	name = dns.Fqdn(name)
+
+	switch {
+	case strings.EqualFold(name, origin):
+		return "@"
+	case origin == ".":
+		return name
+	case dns.IsSubDomain(origin, name):
+		return name[:len(name)-len(origin)-1]
+	}
+
+	return name
+}
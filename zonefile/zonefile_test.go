@@ -0,0 +1,108 @@
+package zonefile_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/frantjc/external-dns-dnsserver-webhook/zonefile"
+	"github.com/miekg/dns"
+)
+
+const db = `$ORIGIN frantj.cc.
+$TTL 300
+@	IN	SOA	ns hostmaster 1 7200 1800 1209600 60
+@	IN	NS	ns
+@	IN	A	10.0.0.1
+www	60	IN	CNAME	@
+app.apps	IN	AAAA	fd00::1
+txt	IN	TXT	"part one" "part two"
+lb.example.com.	IN	A	10.0.0.2
+`
+
+func TestDecode(t *testing.T) {
+	z, err := zonefile.Decode(strings.NewReader(db), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if z.Origin != "example.com." {
+		t.Fatalf("expected origin example.com., got %s", z.Origin)
+	}
+
+	expected := []string{
+		"frantj.cc. 300 IN SOA ns.frantj.cc. hostmaster.frantj.cc. 1 7200 1800 1209600 60",
+		"frantj.cc. 300 IN NS ns.frantj.cc.",
+		"frantj.cc. 300 IN A 10.0.0.1",
+		"www.frantj.cc. 60 IN CNAME frantj.cc.",
+		"app.apps.frantj.cc. 300 IN AAAA fd00::1",
+		`txt.frantj.cc. 300 IN TXT "part one" "part two"`,
+		"lb.example.com. 300 IN A 10.0.0.2",
+	}
+
+	if len(z.Records) != len(expected) {
+		t.Fatalf("expected %d records, got %d: %v", len(expected), len(z.Records), z.Records)
+	}
+
+	for i, s := range expected {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !dns.IsDuplicate(rr, z.Records[i]) || rr.Header().Ttl != z.Records[i].Header().Ttl {
+			t.Fatalf("expected %s, got %s", rr, z.Records[i])
+		}
+	}
+
+	if _, err := zonefile.Decode(strings.NewReader("www IN A not-an-ip\n"), "frantj.cc"); err == nil {
+		t.Fatal("expected error for invalid record")
+	}
+
+	if _, err := zonefile.Decode(strings.NewReader("$INCLUDE /etc/passwd\n"), "frantj.cc"); err == nil {
+		t.Fatal("expected error for $INCLUDE")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	z, err := zonefile.Decode(strings.NewReader(db), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.Origin = "frantj.cc."
+	z.TTL = 300
+
+	b := new(bytes.Buffer)
+	if err := z.Encode(b); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"$ORIGIN frantj.cc.\n",
+		"$TTL 300\n",
+		"@\t\tIN\tA\t10.0.0.1\n",
+		"www\t60\tIN\tCNAME\tfrantj.cc.\n",
+		"app.apps\t\tIN\tAAAA\tfd00::1\n",
+		"lb.example.com.\t\tIN\tA\t10.0.0.2\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Fatalf("expected %q in:\n%s", line, b.String())
+		}
+	}
+
+	// Decoding what was encoded gives back the same records.
+	decoded, err := zonefile.Decode(bytes.NewReader(b.Bytes()), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded.Records) != len(z.Records) {
+		t.Fatalf("expected %d records, got %d", len(z.Records), len(decoded.Records))
+	}
+
+	for i := range z.Records {
+		if !dns.IsDuplicate(z.Records[i], decoded.Records[i]) || z.Records[i].Header().Ttl != decoded.Records[i].Header().Ttl {
+			t.Fatalf("expected %s, got %s", z.Records[i], decoded.Records[i])
+		}
+	}
+}